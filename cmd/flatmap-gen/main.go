@@ -0,0 +1,301 @@
+// Command flatmap-gen generates typed FlatMap wrappers for the tables of a .fbs schema.
+//
+// Tables get a wrapper when some of their fields carry the flatmap_key attribute, one field
+// per tree level, ordered by the attribute value when it has one:
+//
+//	attribute "flatmap_key";
+//
+//	table Book {
+//	    id: ulong (flatmap_key);
+//	    title: string;
+//	}
+//
+// For Book it writes a BookMap with Get(id uint64), Set(*BookT), Delete(id uint64) and a
+// NewBookMap constructor, next to the code flatc generates with --go --gen-object-api.
+// When the key fields have different types, the map is keyed by flatmap.Key.
+// The leaves are read as the list table of the schema whose first field is children: [Book],
+// or as a flatmap.LeafList when it declares none.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+const keyAttribute = "flatmap_key"
+
+func main() {
+	schemaPath := flag.String("schema", "", "the .fbs or .bfbs schema")
+	pkg := flag.String("package", "", "the Go package of the flatc output, the last namespace component by default")
+	out := flag.String("out", "", "the output file, <schema>_flatmap.go by default")
+	flag.Parse()
+	if *schemaPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*schemaPath, *pkg, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "flatmap-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaPath, pkg, out string) error {
+	schema, err := flatmap.LoadSchema(schemaPath)
+	if err != nil {
+		return err
+	}
+	if pkg == "" {
+		pkg = schema.Namespace[strings.LastIndexByte(schema.Namespace, '.')+1:]
+	}
+	if pkg == "" {
+		return fmt.Errorf("the schema has no namespace, set -package")
+	}
+	if out == "" {
+		base := filepath.Base(schemaPath)
+		out = filepath.Join(filepath.Dir(schemaPath), strings.TrimSuffix(base, filepath.Ext(base))+"_flatmap.go")
+	}
+	src, err := generate(schema, filepath.Base(schemaPath), pkg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
+
+type mapData struct {
+	Name    string // the Go type of the table
+	List    string // the Go type of its list table
+	KeyType string // flatmap.Key when the key fields have different types
+	Keys    []keyData
+}
+
+type keyData struct {
+	Param     string // the parameter of Get and Delete
+	Type      string // the Go type of the parameter
+	Accessor  string // the method of V and the field of VT
+	Getter    string // reads the key from v
+	Base      flatmap.BaseType
+	Composite bool
+}
+
+// Expr converts the key value to the key type of the map.
+func (k keyData) Expr(value string) string {
+	if !k.Composite {
+		return value
+	}
+	switch k.Base {
+	case flatmap.BaseTypeString:
+		return "flatmap.StringKey(" + value + ")"
+	case flatmap.BaseTypeLong:
+		return "flatmap.IntKey(" + value + ")"
+	case flatmap.BaseTypeULong:
+		return "flatmap.UintKey(" + value + ")"
+	case flatmap.BaseTypeByte, flatmap.BaseTypeShort, flatmap.BaseTypeInt:
+		return "flatmap.IntKey(int64(" + value + "))"
+	}
+	return "flatmap.UintKey(uint64(" + value + "))"
+}
+
+func generate(schema *flatmap.Schema, source, pkg string) ([]byte, error) {
+	var maps []mapData
+	for _, o := range schema.Objects {
+		if o.IsStruct || schema.Namespace != "" && !strings.HasPrefix(o.Name, schema.Namespace+".") {
+			continue
+		}
+		m, ok, err := tableMap(schema, o)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			maps = append(maps, m)
+		}
+	}
+	if len(maps) == 0 {
+		return nil, fmt.Errorf("no table has a %s field", keyAttribute)
+	}
+	var buf bytes.Buffer
+	err := fileTemplate.Execute(&buf, struct {
+		Source  string
+		Package string
+		Maps    []mapData
+	}{source, pkg, maps})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+func tableMap(schema *flatmap.Schema, o *flatmap.SchemaObject) (mapData, bool, error) {
+	var fields []*flatmap.SchemaField
+	for _, f := range o.Fields {
+		if _, ok := f.Attributes[keyAttribute]; ok {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return mapData{}, false, nil
+	}
+	slices.SortStableFunc(fields, func(a, b *flatmap.SchemaField) int {
+		la, _ := strconv.Atoi(a.Attributes[keyAttribute])
+		lb, _ := strconv.Atoi(b.Attributes[keyAttribute])
+		return la - lb
+	})
+	m := mapData{Name: o.ShortName()}
+	for _, f := range fields {
+		keyType, ok := goKeyTypes[f.Type.Base]
+		if !ok {
+			return m, false, fmt.Errorf("%s.%s: key fields must be integers or strings", m.Name, f.Name)
+		}
+		if f.Type.Enum != nil {
+			keyType = f.Type.Enum.Name[strings.LastIndexByte(f.Type.Enum.Name, '.')+1:]
+		}
+		k := keyData{Param: param(f.Name), Type: keyType, Accessor: camel(f.Name), Base: f.Type.Base}
+		k.Getter = "v." + k.Accessor + "()"
+		if f.Type.Base == flatmap.BaseTypeString {
+			k.Getter = "string(" + k.Getter + ")"
+		}
+		if m.KeyType == "" {
+			m.KeyType = keyType
+		} else if m.KeyType != keyType {
+			m.KeyType = "flatmap.Key"
+		}
+		m.Keys = append(m.Keys, k)
+	}
+	for i := range m.Keys {
+		m.Keys[i].Composite = m.KeyType == "flatmap.Key"
+	}
+	m.List = listTable(schema, o)
+	return m, true, nil
+}
+
+// listTable returns the table whose first field is children: [o], or flatmap.LeafList when
+// there is none.
+func listTable(schema *flatmap.Schema, o *flatmap.SchemaObject) string {
+	for _, list := range schema.Objects {
+		if list.IsStruct || len(list.Fields) == 0 {
+			continue
+		}
+		f := list.Fields[0]
+		if f.ID == 0 && f.Name == "children" && f.Type.Base == flatmap.BaseTypeVector && f.Type.Object == o {
+			return list.ShortName()
+		}
+	}
+	return fmt.Sprintf("flatmap.LeafList[*%[1]sT, *%[1]s]", o.ShortName())
+}
+
+var goKeyTypes = map[flatmap.BaseType]string{
+	flatmap.BaseTypeByte:   "int8",
+	flatmap.BaseTypeUByte:  "uint8",
+	flatmap.BaseTypeShort:  "int16",
+	flatmap.BaseTypeUShort: "uint16",
+	flatmap.BaseTypeInt:    "int32",
+	flatmap.BaseTypeUInt:   "uint32",
+	flatmap.BaseTypeLong:   "int64",
+	flatmap.BaseTypeULong:  "uint64",
+	flatmap.BaseTypeString: "string",
+}
+
+// camel converts a field name the way flatc names its Go accessors.
+func camel(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = []rune(strings.ToUpper(string(r)))[0]
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func param(name string) string {
+	p := camel(name)
+	p = strings.ToLower(p[:1]) + p[1:]
+	switch p {
+	case "break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough",
+		"for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range",
+		"return", "select", "struct", "switch", "type", "var", "m", "v", "t":
+		return p + "Key"
+	}
+	return p
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by flatmap-gen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+{{range .Maps}}
+// {{.Name}}MapConfig configures a {{.Name}}Map, NewV and AppendKeysFromV are set by New{{.Name}}Map.
+type {{.Name}}MapConfig = flatmap.FlatConfig[{{.KeyType}}, *{{.Name}}T, *{{.Name}}, *{{.List}}]
+
+// {{.Name}}Map is a FlatMap of {{.Name}} keyed by{{range $i, $k := .Keys}}{{if $i}},{{end}} {{$k.Param}}{{end}}.
+type {{.Name}}Map struct {
+	*flatmap.FlatNode[{{.KeyType}}, *{{.Name}}T, *{{.Name}}, *{{.List}}]
+}
+
+// New{{.Name}}Map returns an empty {{.Name}}Map, conf may be nil. UpdateSeconds defaults to 1.
+func New{{.Name}}Map(conf *{{.Name}}MapConfig) *{{.Name}}Map {
+	if conf == nil {
+		conf = &{{.Name}}MapConfig{}
+	}
+	if conf.NewV == nil {
+		conf.NewV = func() *{{.Name}} { return &{{.Name}}{} }
+	}
+	if conf.GetKeysFromV == nil && conf.AppendKeysFromV == nil {
+		conf.AppendKeysFromV = Append{{.Name}}MapKeys
+	}
+	if conf.UpdateSeconds == 0 {
+		conf.UpdateSeconds = 1
+	}
+	return &{{.Name}}Map{flatmap.NewFlatNode(conf, 0)}
+}
+
+// Append{{.Name}}MapKeys appends the key path of a {{.Name}} to dst.
+func Append{{.Name}}MapKeys(dst []{{.KeyType}}, v *{{.Name}}) []{{.KeyType}} {
+	return append(dst{{range .Keys}}, {{.Expr .Getter}}{{end}})
+}
+
+// Get returns the {{.Name}} stored under the key, it reads the current buffer of its shard.
+func (m *{{.Name}}Map) Get({{range $i, $k := .Keys}}{{if $i}}, {{end}}{{$k.Param}} {{$k.Type}}{{end}}) (*{{.Name}}, bool) {
+	v := &{{.Name}}{}
+	{{if le (len .Keys) 3}}if !m.FlatNode.Get{{len .Keys}}({{range .Keys}}{{.Expr .Param}}, {{end}}v) {
+	{{- else}}if !m.FlatNode.Get([]{{.KeyType}}{ {{- range $i, $k := .Keys}}{{if $i}}, {{end}}{{$k.Expr $k.Param}}{{end -}} }, v) {
+	{{- end}}
+		return nil, false
+	}
+	return v, true
+}
+
+// Set queues t for the next update.
+func (m *{{.Name}}Map) Set(t *{{.Name}}T) error {
+	b := flatbuffers.NewBuilder(256)
+	b.Finish(t.Pack(b))
+	return m.FlatNode.Set(flatmap.DeltaItem[{{.KeyType}}]{
+		Keys: []{{.KeyType}}{ {{- range $i, $k := .Keys}}{{if $i}}, {{end}}{{$k.Expr (print "t." $k.Accessor)}}{{end -}} },
+		Data: b.FinishedBytes(),
+	})
+}
+
+// Delete queues the removal of the {{.Name}} stored under the key.
+func (m *{{.Name}}Map) Delete({{range $i, $k := .Keys}}{{if $i}}, {{end}}{{$k.Param}} {{$k.Type}}{{end}}) {
+	m.FlatNode.Delete([]{{.KeyType}}{ {{- range $i, $k := .Keys}}{{if $i}}, {{end}}{{$k.Expr $k.Param}}{{end -}} })
+}
+{{end}}`))
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestGenerateBooks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "books_flatmap.go")
+	if err := run("../../example/books.fbs", "", out); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../../example/books/books_flatmap.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("the generated code differs from example/books/books_flatmap.go, run go generate in example:\n%s", got)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   []string
+		err    string
+	}{{
+		name: "composite keys",
+		schema: `namespace shop;
+attribute "flatmap_key";
+table Item { name: string (flatmap_key: "1"); shop_id: int (flatmap_key: "0"); }`,
+		want: []string{
+			"type ItemMap struct {\n\t*flatmap.FlatNode[flatmap.Key, *ItemT, *Item, *flatmap.LeafList[*ItemT, *Item]]",
+			"func (m *ItemMap) Get(shopId int32, name string) (*Item, bool)",
+			"m.FlatNode.Get2(flatmap.IntKey(int64(shopId)), flatmap.StringKey(name), v)",
+		},
+	}, {
+		name: "list table",
+		schema: `namespace shop;
+attribute "flatmap_key";
+table Item { id: uint (flatmap_key); type: uint; }
+table Items { children: [Item]; }`,
+		want: []string{
+			"flatmap.FlatNode[uint32, *ItemT, *Item, *Items]",
+			"func (m *ItemMap) Get(id uint32) (*Item, bool)",
+		},
+	}, {
+		name: "keyword parameter",
+		schema: `namespace shop;
+attribute "flatmap_key";
+table Item { type: uint (flatmap_key); }`,
+		want: []string{"func (m *ItemMap) Get(typeKey uint32) (*Item, bool)"},
+	}, {
+		name: "no key",
+		schema: `namespace shop;
+table Item { id: uint; }`,
+		err: "no table has a flatmap_key field",
+	}, {
+		name: "float key",
+		schema: `namespace shop;
+attribute "flatmap_key";
+table Item { price: double (flatmap_key); }`,
+		err: "Item.price: key fields must be integers or strings",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := flatmap.ParseSchema(test.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, err := generate(schema, "shop.fbs", "shop")
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("generate error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(string(src), want) {
+					t.Errorf("the generated code lacks %q:\n%s", want, src)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Code generated by flatmap-gen from books.fbs. DO NOT EDIT.
+
+package books
+
+import (
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+// BookMapConfig configures a BookMap, NewV and AppendKeysFromV are set by NewBookMap.
+type BookMapConfig = flatmap.FlatConfig[uint64, *BookT, *Book, *BookList]
+
+// BookMap is a FlatMap of Book keyed by id.
+type BookMap struct {
+	*flatmap.FlatNode[uint64, *BookT, *Book, *BookList]
+}
+
+// NewBookMap returns an empty BookMap, conf may be nil. UpdateSeconds defaults to 1.
+func NewBookMap(conf *BookMapConfig) *BookMap {
+	if conf == nil {
+		conf = &BookMapConfig{}
+	}
+	if conf.NewV == nil {
+		conf.NewV = func() *Book { return &Book{} }
+	}
+	if conf.GetKeysFromV == nil && conf.AppendKeysFromV == nil {
+		conf.AppendKeysFromV = AppendBookMapKeys
+	}
+	if conf.UpdateSeconds == 0 {
+		conf.UpdateSeconds = 1
+	}
+	return &BookMap{flatmap.NewFlatNode(conf, 0)}
+}
+
+// AppendBookMapKeys appends the key path of a Book to dst.
+func AppendBookMapKeys(dst []uint64, v *Book) []uint64 {
+	return append(dst, v.Id())
+}
+
+// Get returns the Book stored under the key, it reads the current buffer of its shard.
+func (m *BookMap) Get(id uint64) (*Book, bool) {
+	v := &Book{}
+	if !m.FlatNode.Get1(id, v) {
+		return nil, false
+	}
+	return v, true
+}
+
+// Set queues t for the next update.
+func (m *BookMap) Set(t *BookT) error {
+	b := flatbuffers.NewBuilder(256)
+	b.Finish(t.Pack(b))
+	return m.FlatNode.Set(flatmap.DeltaItem[uint64]{
+		Keys: []uint64{t.Id},
+		Data: b.FinishedBytes(),
+	})
+}
+
+// Delete queues the removal of the Book stored under the key.
+func (m *BookMap) Delete(id uint64) {
+	m.FlatNode.Delete([]uint64{id})
+}
@@ -1,5 +1,7 @@
 package main
 
+//go:generate go run ../cmd/flatmap-gen -schema books.fbs -out books/books_flatmap.go
+
 import (
 	"flag"
 	"fmt"
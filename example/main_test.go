@@ -399,6 +399,59 @@ func BenchmarkTwoLevelFlatMapBucketRead(b *testing.B) {
 	}
 }
 
+// manyKeyPaths returns n two-level key paths spread over the buckets
+func manyKeyPaths(n int) [][]int {
+	keyPaths := make([][]int, n)
+	for i := range keyPaths {
+		key := (i*7919)%largeTestSize + 1
+		keyPaths[i] = []int{key % numBuckets, key}
+	}
+	return keyPaths
+}
+
+// BenchmarkTwoLevelFlatMapLoopGet benchmarks looking up 256 keys with one Get each
+func BenchmarkTwoLevelFlatMapLoopGet(b *testing.B) {
+	keyPaths := manyKeyPaths(256)
+	book := &books.Book{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, keys := range keyPaths {
+			if globalTwoLevelFlatMap.Get(keys, book) {
+				_ = book.Id()
+			}
+		}
+	}
+}
+
+// BenchmarkTwoLevelFlatMapGetMany benchmarks looking up 256 keys with GetMany
+func BenchmarkTwoLevelFlatMapGetMany(b *testing.B) {
+	keyPaths := manyKeyPaths(256)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		globalTwoLevelFlatMap.GetMany(keyPaths, func(_ int, book *books.Book, ok bool) {
+			if ok {
+				_ = book.Id()
+			}
+		})
+	}
+}
+
+// BenchmarkTwoLevelFlatMapGetManyParallel benchmarks looking up 256 keys with GetManyParallel
+func BenchmarkTwoLevelFlatMapGetManyParallel(b *testing.B) {
+	keyPaths := manyKeyPaths(256)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		globalTwoLevelFlatMap.GetManyParallel(keyPaths, func(_ int, book *books.Book, ok bool) {
+			if ok {
+				_ = book.Id()
+			}
+		})
+	}
+}
+
 // Two-Level Standard Map Benchmarks
 
 // BenchmarkTwoLevelStdMapSingleRead benchmarks a single read from a two-level std map
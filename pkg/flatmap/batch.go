@@ -0,0 +1,120 @@
+package flatmap
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ApplyBatch applies the deltas as one atomic write across every shard they touch.
+//
+// The affected leaves are rebuilt under a single tree version while readers keep using their
+// previous views; the version is committed once every leaf is done. A Get, GetBatch or
+// GetSnapshot therefore observes either none or all of the batch, whichever shard it reads.
+// Deltas already pending on the affected leaves become visible together with the batch.
+// Batches are serialized. Reads and transactions are not blocked while a batch is applied;
+// views published on other leaves in the meantime become visible with the batch commit.
+// Separate reads are not isolated from each other: two Gets can still straddle a commit.
+func (sn *FlatNode[K, VT, V, VList]) ApplyBatch(batch []DeltaItem[K]) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	for i := range batch {
+		if len(batch[i].Keys) <= sn.level {
+			return fmt.Errorf("no keys provided")
+		}
+		if err := sn.checkPatch(&batch[i]); err != nil {
+			return err
+		}
+	}
+	clock := sn.conf.clock
+	clock.batchMu.Lock()
+	defer clock.batchMu.Unlock()
+
+	// Resolve every leaf before touching any of them, so a bad key path changes nothing
+	grouped := make(map[*FlatNode[K, VT, V, VList]][]DeltaItem[K])
+	for _, delta := range batch {
+		leaf, err := sn.leafFor(delta.Keys)
+		if err != nil {
+			return err
+		}
+		grouped[leaf] = append(grouped[leaf], delta)
+	}
+
+	for leaf := range grouped {
+		leaf.rwMutex.Lock()
+	}
+	// Reserve the version, the leaves are rebuilt without the clock lock so reads and
+	// publications elsewhere go on; those are committed along with the batch
+	clock.mu.Lock()
+	version := clock.allocate()
+	clock.batching = true
+	clock.mu.Unlock()
+	wg := &sync.WaitGroup{}
+	for leaf, deltas := range grouped {
+		leaf.batchVersion = version
+		wg.Add(1)
+		go func() {
+			leaf.applyBatchDeltas(deltas)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	// The switch: every leaf of the batch becomes visible at once
+	clock.mu.Lock()
+	clock.committed.Store(clock.allocated)
+	clock.batching = false
+	oldest := clock.oldestVersion()
+	for leaf := range grouped {
+		leaf.trimViews(oldest)
+		leaf.batchVersion = 0
+	}
+	clock.mu.Unlock()
+	for leaf := range grouped {
+		leaf.rwMutex.Unlock()
+	}
+	return nil
+}
+
+// applyBatchDeltas rebuilds the leaf with the deltas of a batch, the lock being held.
+func (sn *FlatNode[K, VT, V, VList]) applyBatchDeltas(deltas []DeltaItem[K]) {
+	if sn.shardSnapshot != nil && sn.conf.SnapShotMode == SnapshotModeConsumer {
+		sn.initializeLeafFromSnapshot()
+	}
+	sn.appendBulkDeltaIfNeeded(deltas)
+	sn.updateLeafNode()
+}
+
+// leafFor returns the leaf holding keys, creating the missing nodes on the way.
+func (sn *FlatNode[K, VT, V, VList]) leafFor(keys []K) (*FlatNode[K, VT, V, VList], error) {
+	node := sn
+	for {
+		node.rwMutex.Lock()
+		if node.nodeType == NodeUndecided {
+			if node.level+1 == len(keys) {
+				node.nodeType = NodeLeaf
+			} else {
+				node.nodeType = NodeNonLeaf
+			}
+			node.EnsureCapacity()
+		}
+		if node.nodeType == NodeLeaf {
+			node.rwMutex.Unlock()
+			if node.level+1 != len(keys) {
+				return nil, fmt.Errorf("key path length %d does not match the tree depth", len(keys))
+			}
+			return node, nil
+		}
+		if node.level+1 >= len(keys) {
+			node.rwMutex.Unlock()
+			return nil, fmt.Errorf("key path length %d does not match the tree depth", len(keys))
+		}
+		child, ok := node.children[keys[node.level]]
+		if !ok {
+			child = NewFlatNode(node.conf, node.level+1)
+			node.children[keys[node.level]] = child
+		}
+		node.rwMutex.Unlock()
+		node = child
+	}
+}
@@ -0,0 +1,125 @@
+package flatmap_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestApplyBatchIsAtomic(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	// every shard exists before the readers start, only their leaves are published concurrently
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 0, 0), bookDelta(2, 12, 0, 0), bookDelta(2, 33, 0, 0)})
+
+	stop := make(chan struct{})
+	torn := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			txn := node.BeginRead()
+			first, second := &books.Book{}, &books.Book{}
+			txn.Get([]int{1, 11}, first)
+			txn.Get([]int{2, 12}, second)
+			pages11, pages12 := first.PageCount(), second.PageCount()
+			txn.Close()
+			if pages11 != pages12 {
+				torn <- fmt.Errorf("read book 11 at %d and book 12 at %d", pages11, pages12)
+				return
+			}
+		}
+	}()
+	go func() { // plain Gets read the committed version, a later one never goes back
+		defer wg.Done()
+		first, second := &books.Book{}, &books.Book{}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			node.Get([]int{1, 11}, first)
+			node.Get([]int{2, 12}, second)
+			if pages11, pages12 := first.PageCount(), second.PageCount(); pages12 < pages11 || pages12 > 200 {
+				torn <- fmt.Errorf("read book 11 at %d, then book 12 at %d", pages11, pages12)
+				return
+			}
+		}
+	}()
+	for i := uint64(1); i <= 200; i++ {
+		batch := []flatmap.DeltaItem[int]{bookDelta(2, 11, i, 0), bookDelta(2, 12, i, 0), bookDelta(2, 33, i, 0)}
+		if err := node.ApplyBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	select {
+	case err := <-torn:
+		t.Fatal(err)
+	default:
+	}
+	expectPageCount(t, node, []int{1, 11}, 200)
+	expectPageCount(t, node, []int{3, 33}, 200)
+
+	if err := node.ApplyBatch([]flatmap.DeltaItem[int]{bookDelta(1, 11, 1, 0)}); err == nil {
+		t.Fatal("ApplyBatch accepted a key path shorter than the tree")
+	}
+}
+
+func TestApplyBatchDoesNotBlockReaders(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	conf := newBookConfig(1)
+	conf.Indexes = []flatmap.IndexDef[*books.Book]{
+		flatmap.NewIndex("pages", func(b *books.Book) []uint64 {
+			if b.PageCount() == 999 { // holds the batch in the middle of its rebuild
+				close(entered)
+				<-release
+			}
+			return []uint64{b.PageCount()}
+		}),
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 0)})
+	before := node.Version()
+
+	applied := make(chan error)
+	go func() { applied <- node.ApplyBatch([]flatmap.DeltaItem[int]{bookDelta(1, 1, 999, 0)}) }()
+	<-entered
+
+	read := make(chan uint64)
+	go func() {
+		txn := node.BeginRead()
+		defer txn.Close()
+		book := &books.Book{}
+		txn.Get([]int{1}, book)
+		read <- book.PageCount()
+	}()
+	select {
+	case got := <-read:
+		if got != 10 {
+			t.Fatalf("read %d pages during the batch, want 10", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BeginRead blocked while a batch was applied")
+	}
+	if node.Version() != before {
+		t.Fatal("the batch version was committed before its leaves were rebuilt")
+	}
+
+	close(release)
+	if err := <-applied; err != nil {
+		t.Fatal(err)
+	}
+	expectPageCount(t, node, []int{1}, 999)
+}
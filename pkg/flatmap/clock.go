@@ -0,0 +1,136 @@
+package flatmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// treeClock orders view publications across the leaves of a tree. Every publication gets a
+// tree version and readers only use views whose version is committed, which lets a batch
+// publish on several leaves and become visible at once by committing its version.
+type treeClock struct {
+	mu        sync.Mutex // held from version allocation to commit, except by batches
+	committed atomic.Uint64
+	allocated uint64         // latest version given to a publication, guarded by mu
+	batching  bool           // a batch holds an allocated version, guarded by mu
+	batchMu   sync.Mutex     // serializes batches, taken before any leaf lock
+	readers   map[uint64]int // open read transactions per version, guarded by mu
+}
+
+// allocate returns the next tree version. Guarded by mu.
+func (c *treeClock) allocate() uint64 {
+	c.allocated++
+	return c.allocated
+}
+
+// oldestVersion returns the oldest version a reader may still ask for. Guarded by mu.
+func (c *treeClock) oldestVersion() uint64 {
+	oldest := c.committed.Load()
+	for version := range c.readers {
+		oldest = min(oldest, version)
+	}
+	return oldest
+}
+
+// Version returns the latest committed tree version.
+func (sn *FlatNode[K, VT, V, VList]) Version() uint64 {
+	return sn.conf.clock.committed.Load()
+}
+
+// currentView returns the latest view of the leaf whose version is committed.
+func (sn *FlatNode[K, VT, V, VList]) currentView() *View[K, VT, V, VList] {
+	return sn.viewAt(sn.conf.clock.committed.Load())
+}
+
+// viewAt returns the latest view of the leaf published at or before version.
+func (sn *FlatNode[K, VT, V, VList]) viewAt(version uint64) *View[K, VT, V, VList] {
+	view := sn.viewPtr.Load() // never nil
+	for view.version > version {
+		prev := view.prev.Load()
+		if prev == nil { // trimmed, nobody reads that version anymore
+			break
+		}
+		view = prev
+	}
+	return view
+}
+
+// publishView makes newView the current view of the leaf. Outside a batch it is committed
+// right away under the next tree version, inside one it waits for the batch commit. While a
+// batch is being applied, the views published on other leaves wait for its commit too.
+func (sn *FlatNode[K, VT, V, VList]) publishView(newView *View[K, VT, V, VList]) {
+	sn.buildSecondaryIndexes(newView)
+	sn.buildSortedKeys(newView)
+	sn.commitView(newView)
+}
+
+// commitView publishes a view whose secondary indexes and sorted keys are already set, it
+// builds the list GetBatch returns.
+func (sn *FlatNode[K, VT, V, VList]) commitView(newView *View[K, VT, V, VList]) {
+	if newView.buffer != nil {
+		newView.list = sn.GetRootAsVList(newView.buffer)
+	}
+	if sn.batchVersion != 0 {
+		sn.installView(newView, sn.batchVersion)
+		return
+	}
+	clock := sn.conf.clock
+	clock.mu.Lock()
+	version := clock.allocate()
+	sn.installView(newView, version)
+	if !clock.batching { // otherwise committed with the batch, see ApplyBatch
+		clock.committed.Store(version)
+	}
+	sn.trimViews(clock.oldestVersion())
+	clock.mu.Unlock()
+}
+
+func (sn *FlatNode[K, VT, V, VList]) installView(newView *View[K, VT, V, VList], version uint64) {
+	newView.version = version
+	newView.prev.Store(sn.viewPtr.Load())
+	sn.viewPtr.Store(newView)
+}
+
+// trimViews drops the views nobody can reach anymore: the ones older than the view visible
+// at the oldest version still being read, beyond the history the config retains. Guarded by
+// the clock mutex.
+func (sn *FlatNode[K, VT, V, VList]) trimViews(oldest uint64) {
+	view := sn.viewPtr.Load() // never nil
+	var depth, bytes int
+	for {
+		prev := view.prev.Load()
+		if prev == nil {
+			return
+		}
+		depth++
+		bytes += len(prev.buffer)
+		needed := view.version > oldest // prev may be the view a reader sees
+		retained := depth <= sn.conf.HistoryDepth && (sn.conf.HistoryBytes == 0 || bytes <= sn.conf.HistoryBytes)
+		if !needed && !retained {
+			view.prev.Store(nil)
+			return
+		}
+		view = prev
+	}
+}
+
+// claimWriteBuffer returns the write buffer emptied, replacing it first when a view that is
+// still reachable reads from it.
+func (sn *FlatNode[K, VT, V, VList]) claimWriteBuffer() []byte {
+	for view := sn.viewPtr.Load(); view != nil; view = view.prev.Load() {
+		if sameBuffer(view.buffer, sn.WriteBuffer) {
+			sn.WriteBuffer = make([]byte, 0, cap(sn.WriteBuffer))
+			break
+		}
+	}
+	return sn.WriteBuffer[:0]
+}
+
+// sameBuffer reports whether a and b share their backing array. Finished flatbuffers are
+// the tail of the builder bytes, so slices of one buffer end at the same element.
+func sameBuffer(a, b []byte) bool {
+	if cap(a) == 0 || cap(b) == 0 {
+		return false
+	}
+	return &a[:cap(a)][cap(a)-1] == &b[:cap(b)][cap(b)-1]
+}
@@ -0,0 +1,39 @@
+package flatmap_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 1)})
+
+	first, err := node.CompareAndSet([]int{1}, 1, bookDelta(1, 1, 20, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := node.CompareAndSet([]int{1}, 1, bookDelta(1, 1, 30, 0)) // sees version 2
+	created, _ := node.SetIf(bookDelta(1, 2, 5, 0), func(_ *books.Book, exists bool) bool { return !exists })
+	chained, _ := node.SetIf(bookDelta(1, 1, 99, 0), func(old *books.Book, exists bool) bool {
+		return exists && old.PageCount() == 20 // the accepted CompareAndSet is visible
+	})
+	node.Update(nil)
+
+	got := []bool{<-first, <-second, <-created, <-chained}
+	if want := []bool{true, false, true, true}; !slices.Equal(got, want) {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+	expectPageCount(t, node, []int{1}, 99)
+	expectPageCount(t, node, []int{2}, 5)
+
+	if _, err := node.SetIf(bookDelta(1, 3, 1, 0), nil); err == nil {
+		t.Fatal("SetIf without a condition succeeded")
+	}
+	if _, err := node.CompareAndSet(nil, 0, bookDelta(1, 3, 1, 0)); err == nil {
+		t.Fatal("CompareAndSet without keys succeeded")
+	}
+}
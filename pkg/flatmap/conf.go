@@ -6,11 +6,35 @@ func (fc *FlatConfig[K, VT, V, VList]) Validate() error {
 	if fc.NewV == nil {
 		return fmt.Errorf("NewV is nil")
 	}
-	if fc.NewVList == nil {
-		return fmt.Errorf("NewVList is nil")
-	}
-	if fc.GetKeysFromV == nil {
+	if fc.GetKeysFromV == nil && fc.AppendKeysFromV == nil {
 		return fmt.Errorf("GetKeysFromV is nil")
 	}
+	if fc.SortedLayout && fc.CompareKeys == nil {
+		return fmt.Errorf("SortedLayout needs CompareKeys")
+	}
+	if fc.OpenKeyIndex != nil && fc.NewKeyIndex == nil {
+		return fmt.Errorf("OpenKeyIndex needs NewKeyIndex")
+	}
+	if fc.FilterFalsePositiveRate > 0 && fc.HashKey == nil && defaultHashKey[K]() == nil {
+		return fmt.Errorf("FilterFalsePositiveRate needs HashKey for %T keys", *new(K))
+	}
+	if fc.FilterFalsePositiveRate >= 1 {
+		return fmt.Errorf("FilterFalsePositiveRate must be below 1")
+	}
+	if fc.Schema != nil && fc.MergePatch == nil {
+		if _, err := schemaMergePatch[VT, V](fc.Schema); err != nil {
+			return fmt.Errorf("Schema does not match the object API: %w", err)
+		}
+	}
+	names := make(map[string]struct{}, len(fc.Indexes))
+	for _, index := range fc.Indexes {
+		if index.keys == nil {
+			return fmt.Errorf("index %q is not created with NewIndex", index.Name)
+		}
+		if _, ok := names[index.Name]; ok {
+			return fmt.Errorf("index %q is defined twice", index.Name)
+		}
+		names[index.Name] = struct{}{}
+	}
 	return nil
 }
@@ -0,0 +1,161 @@
+package flatmap
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+type diffKind int
+
+const (
+	diffAdded diffKind = iota
+	diffRemoved
+	diffChanged
+)
+
+// Diff compares two snapshots of the same shard and returns the keys, at the shard level,
+// that only b holds, that only a holds and that both hold with different contents. Entries
+// are compared by their re-serialized bytes, so two encodings of the same value are equal.
+// A nil snapshot is an empty shard.
+func (sn *FlatNode[K, VT, V, VList]) Diff(a, b *ShardSnapshot[K]) (added, removed, changed []K) {
+	aKeys, aList := sn.snapshotList(a)
+	bKeys, bList := sn.snapshotList(b)
+	sn.diffEntries(aKeys, aList, bKeys, bList, func(kind diffKind, key K, _ []byte) {
+		switch kind {
+		case diffAdded:
+			added = append(added, key)
+		case diffRemoved:
+			removed = append(removed, key)
+		case diffChanged:
+			changed = append(changed, key)
+		}
+	})
+	return added, removed, changed
+}
+
+// DiffDeltas returns the deltas that bring a shard holding a to the state of b: a write for
+// every added or changed entry and a Delete for every removed one.
+func (sn *FlatNode[K, VT, V, VList]) DiffDeltas(a, b *ShardSnapshot[K]) []DeltaItem[K] {
+	var path []K
+	if b != nil {
+		path = b.Path
+	} else if a != nil {
+		path = a.Path
+	}
+	aKeys, aList := sn.snapshotList(a)
+	bKeys, bList := sn.snapshotList(b)
+	var deltas []DeltaItem[K]
+	sn.diffEntries(aKeys, aList, bKeys, bList, func(kind diffKind, key K, data []byte) {
+		deltas = append(deltas, diffDelta(kind, append(slices.Clip(path), key), data))
+	})
+	return deltas
+}
+
+// DiffVersions compares the tree at two committed versions, see Diff, and returns the full
+// key paths of the entries. Both versions must still be retained by every leaf, see GetAt.
+func (sn *FlatNode[K, VT, V, VList]) DiffVersions(from, to uint64) (added, removed, changed [][]K, err error) {
+	err = sn.diffVersions(from, to, func(kind diffKind, keys []K, _ []byte) {
+		switch kind {
+		case diffAdded:
+			added = append(added, keys)
+		case diffRemoved:
+			removed = append(removed, keys)
+		case diffChanged:
+			changed = append(changed, keys)
+		}
+	})
+	return added, removed, changed, err
+}
+
+// DiffVersionDeltas returns the deltas that bring a tree at version from to version to.
+func (sn *FlatNode[K, VT, V, VList]) DiffVersionDeltas(from, to uint64) ([]DeltaItem[K], error) {
+	var deltas []DeltaItem[K]
+	err := sn.diffVersions(from, to, func(kind diffKind, keys []K, data []byte) {
+		deltas = append(deltas, diffDelta(kind, keys, data))
+	})
+	return deltas, err
+}
+
+func diffDelta[K comparable](kind diffKind, keys []K, data []byte) DeltaItem[K] {
+	if kind == diffRemoved {
+		return DeltaItem[K]{Keys: keys, Delete: true}
+	}
+	return DeltaItem[K]{Keys: keys, Data: data}
+}
+
+func (sn *FlatNode[K, VT, V, VList]) diffVersions(from, to uint64, emit func(kind diffKind, keys []K, data []byte)) error {
+	committed := sn.conf.clock.committed.Load()
+	if from > committed || to > committed {
+		return fmt.Errorf("version %d is not committed", max(from, to))
+	}
+	return sn.diffLeavesAt(from, to, nil, emit)
+}
+
+func (sn *FlatNode[K, VT, V, VList]) diffLeavesAt(from, to uint64, path []K, emit func(kind diffKind, keys []K, data []byte)) error {
+	switch sn.nodeType {
+	case NodeNonLeaf:
+		for key, child := range sn.children {
+			if err := child.diffLeavesAt(from, to, append(slices.Clip(path), key), emit); err != nil {
+				return err
+			}
+		}
+	case NodeLeaf:
+		a, okA := sn.retainedView(from)
+		b, okB := sn.retainedView(to)
+		if !okA || !okB {
+			return fmt.Errorf("versions %d and %d are not both retained for path %v", from, to, path)
+		}
+		if a == b {
+			return nil
+		}
+		sn.diffEntries(sn.viewKeys(a), a.Vlist, sn.viewKeys(b), b.Vlist, func(kind diffKind, key K, data []byte) {
+			emit(kind, append(slices.Clip(path), key), data)
+		})
+	}
+	return nil
+}
+
+// diffEntries reports the entries of the b list that are missing from or differ in the a
+// list, in b order, then the entries of a missing from b. data is the b entry serialized on
+// its own, nil for removed entries.
+func (sn *FlatNode[K, VT, V, VList]) diffEntries(aKeys []K, aList LeafList[VT, V], bKeys []K, bList LeafList[VT, V], emit func(kind diffKind, key K, data []byte)) {
+	aIndexes := make(map[K]int, len(aKeys))
+	for i, key := range aKeys {
+		aIndexes[key] = i
+	}
+	bIndexes := make(map[K]struct{}, len(bKeys))
+	var aObj V = sn.conf.NewV()
+	var bObj V = sn.conf.NewV()
+	for i, key := range bKeys {
+		bIndexes[key] = struct{}{}
+		if !bList.Children(bObj, i) {
+			continue
+		}
+		data := packV(bObj.UnPack())
+		j, ok := aIndexes[key]
+		if !ok || !aList.Children(aObj, j) {
+			emit(diffAdded, key, data)
+			continue
+		}
+		if !bytes.Equal(packV(aObj.UnPack()), data) {
+			emit(diffChanged, key, data)
+		}
+	}
+	for _, key := range aKeys {
+		if _, ok := bIndexes[key]; !ok {
+			emit(diffRemoved, key, nil)
+		}
+	}
+}
+
+func (sn *FlatNode[K, VT, V, VList]) snapshotList(snapshot *ShardSnapshot[K]) (keys []K, list LeafList[VT, V]) {
+	if snapshot == nil || len(snapshot.Buffer) == 0 {
+		return nil, list
+	}
+	list = sn.leafList(snapshot.Buffer)
+	if snapshot.Keys == nil { // self-indexing snapshot of a sorted layout
+		return sn.listKeys(list, len(snapshot.Path)), list
+	}
+	return snapshot.Keys, list
+}
@@ -0,0 +1,87 @@
+package flatmap_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestDiff(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0), bookDelta(2, 21, 1, 0), bookDelta(2, 41, 1, 0)})
+	before := node.GetSnapshot([]int{1}, true)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{
+		bookDelta(2, 11, 2, 0), // changed
+		bookDelta(2, 21, 1, 0), // rewritten with the same content
+		bookDelta(2, 31, 1, 0), // added
+		{Keys: []int{1, 41}, Delete: true},
+	})
+	after := node.GetSnapshot([]int{1}, true)
+
+	added, removed, changed := node.Diff(before, after)
+	if !slices.Equal(added, []int{31}) || !slices.Equal(removed, []int{41}) || !slices.Equal(changed, []int{11}) {
+		t.Fatalf("Diff = %v, %v, %v, want [31], [41], [11]", added, removed, changed)
+	}
+	if added, removed, _ := node.Diff(nil, after); len(added) != 3 || len(removed) != 0 {
+		t.Fatalf("Diff from an empty shard = %v added, %v removed", added, removed)
+	}
+
+	replica := flatmap.NewFlatNode(newBookConfig(2), 0)
+	replica.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0), bookDelta(2, 21, 1, 0), bookDelta(2, 41, 1, 0)})
+	replica.FeedDeltaBulk(node.DiffDeltas(before, after))
+	expectSameBooks(t, node, replica, [][]int{{1, 11}, {1, 21}, {1, 31}, {1, 41}})
+}
+
+func TestDiffVersions(t *testing.T) {
+	conf := newBookConfig(2)
+	conf.HistoryDepth = 4
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0), bookDelta(2, 12, 1, 0), bookDelta(2, 21, 1, 0)})
+	from := node.Version()
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 2, 0), bookDelta(2, 31, 1, 0), {Keys: []int{2, 12}, Delete: true}})
+	to := node.Version()
+
+	added, removed, changed, err := node.DiffVersions(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || !slices.Equal(added[0], []int{1, 31}) ||
+		len(removed) != 1 || !slices.Equal(removed[0], []int{2, 12}) ||
+		len(changed) != 1 || !slices.Equal(changed[0], []int{1, 11}) {
+		t.Fatalf("DiffVersions = %v, %v, %v", added, removed, changed)
+	}
+
+	deltas, err := node.DiffVersionDeltas(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica := flatmap.NewFlatNode(newBookConfig(2), 0)
+	replica.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0), bookDelta(2, 12, 1, 0), bookDelta(2, 21, 1, 0)})
+	replica.FeedDeltaBulk(deltas)
+	expectSameBooks(t, node, replica, [][]int{{1, 11}, {2, 12}, {1, 21}, {1, 31}})
+
+	if _, _, _, err := node.DiffVersions(from, node.Version()+1); err == nil {
+		t.Fatal("DiffVersions succeeded on an uncommitted version")
+	}
+}
+
+func TestWriteAfterDelete(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 1, 0)})
+	node.Delete([]int{1})
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 2, 1, 0)})
+	expectPageCount(t, node, []int{1}, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 5, 0)})
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 3, 1, 0)}) // survives the next rebuild
+	expectPageCount(t, node, []int{1}, 5)
+}
+
+func expectSameBooks(t *testing.T, want, got *bookNode, keyPaths [][]int) {
+	t.Helper()
+	for _, keys := range keyPaths {
+		if w, g := pageCount(want, keys), pageCount(got, keys); w != g {
+			t.Fatalf("page count of %v = %d, want %d", keys, g, w)
+		}
+	}
+}
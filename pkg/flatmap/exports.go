@@ -1,9 +1,16 @@
 package flatmap
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
 
 // Get retrieves a value from the shard tree given a set of keys. DO NOT PASS A NIL VALUE
 func (sn *FlatNode[K, VT, V, VList]) Get(keys []K, v V) bool {
+	return sn.getAt(keys, v, sn.conf.clock.committed.Load())
+}
+
+func (sn *FlatNode[K, VT, V, VList]) getAt(keys []K, v V, version uint64) bool {
 	// example call Get([]uint64{mp_id: 1, cmp_id: 2, c_id: 3})
 	if len(keys) == 0 {
 		return false
@@ -16,26 +23,108 @@ func (sn *FlatNode[K, VT, V, VList]) Get(keys []K, v V) bool {
 		if !ok {
 			return false
 		}
-		return child.Get(keys, v)
+		return child.getAt(keys, v, version)
 	}
-	view := *sn.viewPtr // never nil
+	return sn.getLeaf(keys[sn.level], v, version)
+}
 
-	index, ok := view.indexes[keys[sn.level]]
-	if !ok {
+// GetInto unpacks the value at keys into vt, reusing its fields instead of allocating a new
+// object as UnPack does.
+func (sn *FlatNode[K, VT, V, VList]) GetInto(keys []K, vt VT) bool {
+	probe := sn.conf.probes.Get().(V)
+	defer sn.conf.probes.Put(probe)
+	if !sn.Get(keys, probe) {
 		return false
 	}
-	if index < 0 {
+	probe.UnPackTo(vt)
+	return true
+}
+
+// Has reports whether keys hold a value. It only checks the index of the shard, except with
+// a sorted layout where the keys of the buffer are binary searched.
+func (sn *FlatNode[K, VT, V, VList]) Has(keys []K) bool {
+	leaf := sn.leafAt(keys)
+	if leaf == nil {
 		return false
 	}
-	if !view.Vlist.Children(v, int(index)) {
+	view := leaf.viewAt(sn.conf.clock.committed.Load())
+	if !leaf.mayContain(view, keys[leaf.level]) {
 		return false
 	}
+	if !sn.conf.SortedLayout {
+		_, ok := view.indexes.Lookup(keys[leaf.level])
+		return ok
+	}
+	probe := sn.conf.probes.Get().(V)
+	defer sn.conf.probes.Put(probe)
+	_, ok := leaf.lookup(view, keys[leaf.level], probe)
+	return ok
+}
+
+// GetOr returns v loaded with the value at keys, or fallback when there is none.
+func (sn *FlatNode[K, VT, V, VList]) GetOr(keys []K, v V, fallback V) V {
+	if sn.Get(keys, v) {
+		return v
+	}
+	return fallback
+}
 
-	return true
+// leafAt returns the leaf holding keys, nil when there is none.
+func (sn *FlatNode[K, VT, V, VList]) leafAt(keys []K) *FlatNode[K, VT, V, VList] {
+	node := sn
+	for node != nil && node.nodeType == NodeNonLeaf && node.level < len(keys) {
+		node = node.children[keys[node.level]]
+	}
+	if node == nil || node.nodeType != NodeLeaf || node.level >= len(keys) {
+		return nil
+	}
+	return node
+}
+
+// Get1 is Get for trees of one level, it does not need a key slice.
+func (sn *FlatNode[K, VT, V, VList]) Get1(k0 K, v V) bool {
+	return sn.getLeaf(k0, v, sn.conf.clock.committed.Load())
+}
+
+// Get2 is Get for trees of two levels, it does not need a key slice.
+func (sn *FlatNode[K, VT, V, VList]) Get2(k0, k1 K, v V) bool {
+	version := sn.conf.clock.committed.Load()
+	return sn.child(k0).getLeaf(k1, v, version)
+}
+
+// Get3 is Get for trees of three levels, it does not need a key slice.
+func (sn *FlatNode[K, VT, V, VList]) Get3(k0, k1, k2 K, v V) bool {
+	version := sn.conf.clock.committed.Load()
+	return sn.child(k0).child(k1).getLeaf(k2, v, version)
+}
+
+// child returns the child of a non-leaf node under key, nil when there is none.
+func (sn *FlatNode[K, VT, V, VList]) child(key K) *FlatNode[K, VT, V, VList] {
+	if sn == nil || sn.nodeType != NodeNonLeaf {
+		return nil
+	}
+	return sn.children[key]
+}
+
+// getLeaf reads the child under key of a leaf node, sn may be nil.
+func (sn *FlatNode[K, VT, V, VList]) getLeaf(key K, v V, version uint64) bool {
+	if sn == nil || sn.nodeType != NodeLeaf {
+		return false
+	}
+	view := sn.viewAt(version)
+	index, ok := sn.lookup(view, key, v)
+	if !ok || index < 0 {
+		return false
+	}
+	return view.Vlist.Children(v, index)
 }
 
 // Get retrieves a value from the shard tree given a set of keys.
 func (sn *FlatNode[K, VT, V, VList]) GetBatch(keys []K) (vList VList, found bool) {
+	return sn.getBatchAt(keys, sn.conf.clock.committed.Load())
+}
+
+func (sn *FlatNode[K, VT, V, VList]) getBatchAt(keys []K, version uint64) (vList VList, found bool) {
 	// example call Get([]uint64{mp_id: 1, cmp_id: 2, c_id: 3})
 	if len(keys) == 0 && sn.nodeType != NodeLeaf {
 		return
@@ -48,53 +137,73 @@ func (sn *FlatNode[K, VT, V, VList]) GetBatch(keys []K) (vList VList, found bool
 		if !ok {
 			return
 		}
-		return child.GetBatch(keys)
+		return child.getBatchAt(keys, version)
+	}
+	view := sn.viewAt(version)
+	if view.buffer == nil { // nothing published at that version
+		return
 	}
-	return sn.viewPtr.Vlist, true
+	return view.list, true
 }
 
+// GetSnapshot returns the snapshot of the shard holding keys, nil when it is empty. Its Path
+// is the prefix of keys above the leaf level, the shape InitializeWithGroupedShardBuffers and
+// SetSnapshot take.
 func (sn *FlatNode[K, VT, V, VList]) GetSnapshot(keys []K, deepCopy bool) *ShardSnapshot[K] {
+	return sn.snapshotAt(keys, deepCopy, sn.conf.clock.committed.Load())
+}
+
+func (sn *FlatNode[K, VT, V, VList]) snapshotAt(keys []K, deepCopy bool, version uint64) *ShardSnapshot[K] {
 	if len(keys) == 0 {
 		return nil
 	}
 	if sn.nodeType != NodeLeaf {
 		child, ok := sn.children[keys[sn.level]]
 		if ok {
-			return child.GetSnapshot(keys, deepCopy)
+			return child.snapshotAt(keys, deepCopy, version)
 		}
 	}
-	sn.rwMutex.RLock()
-	defer sn.rwMutex.RUnlock()
-	view := *sn.viewPtr // never nil
+	return sn.viewSnapshot(sn.viewAt(version), keys[:sn.level:sn.level], deepCopy)
+}
+
+// viewSnapshot returns the snapshot of a leaf view, nil when it is empty. With a sorted
+// layout or an embedded key index the buffer indexes itself and Keys is left nil.
+func (sn *FlatNode[K, VT, V, VList]) viewSnapshot(view *View[K, VT, V, VList], path []K, deepCopy bool) *ShardSnapshot[K] {
 	// check if shard is not empty
-	if len(view.indexes) == 0 {
+	if view.length() == 0 {
 		return nil
 	}
-	keyList := make([]K, 0, len(view.indexes))
-	for k := range view.indexes {
-		keyList = append(keyList, k)
+	var keyList []K
+	if !sn.conf.SortedLayout && sn.embeddedKeyIndex(view.buffer) == nil {
+		keyList = sn.viewKeys(view) // Keys must follow the order of the children in the buffer
 	}
 	if !deepCopy {
 		return &ShardSnapshot[K]{
-			Path:   keys,
-			Keys:   keyList,
-			Buffer: sn.ReadBuffer, // This will be valid until it will be cycled to -> BackupBuffer -> WriteBuffer
+			Path:    path,
+			Keys:    keyList,
+			Buffer:  view.buffer, // rebuilt buffers are never reused, mutated ones are, see MutateInPlace
+			Version: view.maxVersion,
+			Filter:  view.filter,
 		}
 	}
-	dest := make([]byte, len(sn.ReadBuffer))
-	copy(dest, sn.ReadBuffer)
+	dest := make([]byte, len(view.buffer))
+	copy(dest, view.buffer)
 	return &ShardSnapshot[K]{
-		Path:   keys,
-		Keys:   keyList,
-		Buffer: dest,
+		Path:    path,
+		Keys:    keyList,
+		Buffer:  dest,
+		Version: view.maxVersion,
+		Filter:  slices.Clone(view.filter),
 	}
-
 }
 
 func (sn *FlatNode[K, VT, V, VList]) Set(v DeltaItem[K]) error {
 	if len(v.Keys) == 0 {
 		return fmt.Errorf("no keys provided")
 	}
+	if err := sn.checkPatch(&v); err != nil {
+		return err
+	}
 	if sn.nodeType == NodeNonLeaf {
 		child, ok := sn.children[v.Keys[sn.level]]
 		if ok {
@@ -107,14 +216,79 @@ func (sn *FlatNode[K, VT, V, VList]) Set(v DeltaItem[K]) error {
 	return nil
 }
 
-func (sn *FlatNode[K, VT, V, VList]) SetSnapshot(v *ShardSnapshot[K]) error {
-	if len(v.Path) == 0 {
+// SetIf queues a delta that is applied only if cond holds for the entry in the current view
+// when the shard is rebuilt. old is only valid when exists is true. The returned channel
+// receives once, after the rebuild, whether the write was applied.
+func (sn *FlatNode[K, VT, V, VList]) SetIf(v DeltaItem[K], cond func(old V, exists bool) bool) (<-chan bool, error) {
+	if cond == nil {
+		return nil, fmt.Errorf("no condition provided")
+	}
+	return sn.setConditional(v, func(old V, exists bool, _ uint64) bool {
+		return cond(old, exists)
+	})
+}
+
+// CompareAndSet queues a delta that is applied only if the entry at keys still holds
+// expectedVersion when the shard is rebuilt, 0 matching a missing or unversioned entry.
+// An unversioned delta is given expectedVersion+1.
+func (sn *FlatNode[K, VT, V, VList]) CompareAndSet(keys []K, expectedVersion uint64, v DeltaItem[K]) (<-chan bool, error) {
+	v.Keys = keys
+	if v.Version == 0 {
+		v.Version = expectedVersion + 1
+	}
+	return sn.setConditional(v, func(_ V, _ bool, version uint64) bool {
+		return version == expectedVersion
+	})
+}
+
+// Patch queues a read-modify-write of the entry at keys. merge receives the current value,
+// the zero VT when the entry does not exist, and returns the new one. It runs when the shard
+// is rebuilt, so patches of the same entry compose without the caller reading it first.
+func (sn *FlatNode[K, VT, V, VList]) Patch(keys []K, merge func(old VT) VT) error {
+	if merge == nil {
+		return fmt.Errorf("no merge function provided")
+	}
+	return sn.enqueueDeferred(deferredDelta[K, VT, V]{delta: DeltaItem[K]{Keys: keys}, merge: merge})
+}
+
+func (sn *FlatNode[K, VT, V, VList]) setConditional(v DeltaItem[K], check func(old V, exists bool, version uint64) bool) (<-chan bool, error) {
+	result := make(chan bool, 1)
+	if err := sn.enqueueDeferred(deferredDelta[K, VT, V]{delta: v, check: check, result: result}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (sn *FlatNode[K, VT, V, VList]) enqueueDeferred(dd deferredDelta[K, VT, V]) error {
+	if len(dd.delta.Keys) == 0 {
 		return fmt.Errorf("no keys provided")
 	}
+	if err := sn.checkPatch(&dd.delta); err != nil {
+		return err
+	}
+	if sn.nodeType == NodeNonLeaf {
+		child, ok := sn.children[dd.delta.Keys[sn.level]]
+		if ok {
+			return child.enqueueDeferred(dd)
+		}
+	}
+	sn.rwMutex.Lock()
+	sn.pendingDeferred = append(sn.pendingDeferred, dd)
+	sn.rwMutex.Unlock()
+	return nil
+}
+
+// SetSnapshot replaces the content of the shard at v.Path with the snapshot at the next
+// update. Path holds the keys of the levels above the leaf, as GetSnapshot and Loader return
+// it, so it is empty on a one level tree; a path holding the leaf key as well is accepted.
+func (sn *FlatNode[K, VT, V, VList]) SetSnapshot(v *ShardSnapshot[K]) error {
 	if sn.conf.SnapShotMode == SnapshotModeProducer {
 		return fmt.Errorf("snapshot mode is producer")
 	}
 	if sn.nodeType == NodeNonLeaf {
+		if len(v.Path) <= sn.level {
+			return fmt.Errorf("no keys provided")
+		}
 		child, ok := sn.children[v.Path[sn.level]]
 		if ok {
 			return child.SetSnapshot(v)
@@ -131,7 +305,7 @@ func (sn *FlatNode[K, VT, V, VList]) Delete(keys []K) {
 		return
 	}
 	if sn.nodeType == NodeLeaf {
-		if _, ok := sn.viewPtr.indexes[keys[sn.level]]; !ok {
+		if _, ok := sn.lookup(sn.viewPtr.Load(), keys[sn.level], sn.conf.NewV()); !ok {
 			return
 		}
 		sn.rwMutex.Lock()
@@ -145,3 +319,13 @@ func (sn *FlatNode[K, VT, V, VList]) Delete(keys []K) {
 	}
 	child.Delete(keys)
 }
+
+// StaleDeltaCount returns the number of versioned deltas dropped in this subtree
+// because the entry already held the same or a newer version.
+func (sn *FlatNode[K, VT, V, VList]) StaleDeltaCount() uint64 {
+	count := sn.staleDeltas.Load()
+	for _, child := range sn.children {
+		count += child.StaleDeltaCount()
+	}
+	return count
+}
@@ -0,0 +1,129 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func newAppendKeysTree(t *testing.T, sorted bool) *bookNode {
+	t.Helper()
+	conf := newBookConfig(2)
+	conf.GetKeysFromV = nil
+	conf.AppendKeysFromV = func(dst []int, b *books.Book) []int {
+		return append(dst, int(b.Id())%10, int(b.Id()))
+	}
+	conf.SortedLayout = sorted
+	conf.CompareKeys = func(a, b int) int { return a - b }
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := uint64(1); id < 200; id++ {
+		deltas = append(deltas, bookDelta(2, id, id, 0))
+	}
+	node.FeedDeltaBulk(deltas)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{{Keys: []int{1, 11}, Delete: true}})
+	return node
+}
+
+func TestFixedDepthGet(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		node := newAppendKeysTree(t, sorted)
+		book := &books.Book{}
+		if !node.Get2(1, 21, book) || book.PageCount() != 21 {
+			t.Errorf("sorted %v: Get2(1, 21) = %v, want page count 21", sorted, book.PageCount())
+		}
+		if node.Get2(1, 11, book) {
+			t.Errorf("sorted %v: Get2 found the deleted entry", sorted)
+		}
+		if node.Get2(5, 21, book) || node.Get1(1, book) || node.Get3(1, 21, 3, book) {
+			t.Errorf("sorted %v: a wrong key path found an entry", sorted)
+		}
+		if keys := collectKeys(node.Ascend([]int{4}), 0); len(keys) != 20 {
+			t.Errorf("sorted %v: Ascend(4) = %d keys, want 20", sorted, len(keys))
+		}
+	}
+
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 5, 5, 0)})
+	book := &books.Book{}
+	if !node.Get1(5, book) || book.PageCount() != 5 || node.Get2(5, 5, book) {
+		t.Fatal("Get1 on a one level tree")
+	}
+}
+
+func TestGetDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items under the race detector")
+	}
+	for _, sorted := range []bool{false, true} {
+		node := newAppendKeysTree(t, sorted)
+		book := &books.Book{}
+		if allocs := testing.AllocsPerRun(100, func() { node.Get2(3, 133, book) }); allocs != 0 {
+			t.Errorf("sorted %v: Get2 allocates %v times, want 0", sorted, allocs)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { node.Get([]int{3, 133}, book) }); allocs != 0 {
+			t.Errorf("sorted %v: Get allocates %v times, want 0", sorted, allocs)
+		}
+	}
+}
+
+func TestAppendKeysFromVReusesTheBuffer(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.GetKeysFromV = nil
+	var calls, reused int
+	conf.AppendKeysFromV = func(dst []int, b *books.Book) []int {
+		calls++
+		if cap(dst) > 0 {
+			reused++
+		}
+		return append(dst, int(b.Id()))
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := uint64(1); id <= 10; id++ {
+		deltas = append(deltas, bookDelta(1, id, id, 0))
+	}
+	node.FeedDeltaBulk(deltas)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 11, 11, 0)})
+	if reused == 0 { // the pool may drop a buffer now and then
+		t.Fatalf("AppendKeysFromV got a reused buffer %d times out of %d calls", reused, calls)
+	}
+	expectPageCount(t, node, []int{7}, 7)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 0), bookDelta(1, 2, 20, 0)})
+	snapshot := node.GetSnapshot([]int{1}, true)
+	if len(snapshot.Path) != 0 {
+		t.Fatalf("snapshot path = %v on a one level tree, want it empty", snapshot.Path)
+	}
+
+	restored := flatmap.NewFlatNode(newBookConfig(1), 0)
+	restored.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+	restored.Update(nil)
+	expectPageCount(t, restored, []int{1}, 10)
+	expectPageCount(t, restored, []int{2}, 20)
+
+	for _, path := range [][]int{{}, {1}} { // the leaf key is accepted in the path as well
+		target := flatmap.NewFlatNode(newBookConfig(1), 0)
+		target.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 3, 30, 0)})
+		snapshot.Path = path
+		if err := target.SetSnapshot(snapshot); err != nil {
+			t.Fatalf("SetSnapshot with path %v: %v", path, err)
+		}
+		target.Update(nil)
+		expectPageCount(t, target, []int{1}, 10)
+		expectPageCount(t, target, []int{3}, 0)
+	}
+
+	tree := flatmap.NewFlatNode(newBookConfig(2), 0)
+	tree.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 11, 0)})
+	if err := tree.SetSnapshot(&flatmap.ShardSnapshot[int]{}); err == nil {
+		t.Fatal("SetSnapshot accepted an empty path on a two level tree")
+	}
+}
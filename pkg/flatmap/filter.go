@@ -0,0 +1,140 @@
+package flatmap
+
+import (
+	"iter"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// keyFilter is a bloom filter of the keys of a leaf, consulted before its index so most
+// missing keys are rejected without probing it. The first byte holds the number of hash
+// functions, the rest the bit array; the layout does not depend on the process, so filters
+// travel with ShardSnapshot.Filter.
+type keyFilter []byte
+
+// newKeyFilter returns an empty filter sized for n keys at the false positive rate.
+func newKeyFilter(n int, rate float64) keyFilter {
+	n = max(n, 1)
+	rate = min(max(rate, 1e-9), 0.5)
+	bitCount := math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(bitCount / float64(n) * math.Ln2))
+	filter := make(keyFilter, 1+(int(bitCount)+7)/8)
+	filter[0] = byte(min(max(hashes, 1), 16))
+	return filter
+}
+
+// valid reports whether the filter can be read, a snapshot may carry anything.
+func (f keyFilter) valid() bool {
+	return len(f) > 1 && f[0] > 0 && f[0] <= 16 && len(f)-1 <= math.MaxUint32/8
+}
+
+func (f keyFilter) add(hash uint64) {
+	h1, h2, bitCount := uint32(hash), uint32(hash>>32)|1, uint64(len(f)-1)*8
+	for i := range uint32(f[0]) {
+		bit := (uint64(h1+i*h2) * bitCount) >> 32
+		f[1+bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (f keyFilter) mayContain(hash uint64) bool {
+	h1, h2, bitCount := uint32(hash), uint32(hash>>32)|1, uint64(len(f)-1)*8
+	for i := range uint32(f[0]) {
+		bit := (uint64(h1+i*h2) * bitCount) >> 32
+		if f[1+bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// buildFilter returns the filter of the n keys, nil when filters are off.
+func (sn *FlatNode[K, VT, V, VList]) buildFilter(n int, keys iter.Seq[K]) keyFilter {
+	if sn.conf.FilterFalsePositiveRate <= 0 || sn.conf.hashKey == nil {
+		return nil
+	}
+	filter := newKeyFilter(n, sn.conf.FilterFalsePositiveRate)
+	for key := range keys {
+		filter.add(sn.conf.hashKey(key))
+	}
+	return filter
+}
+
+// snapshotFilter returns the filter a snapshot carries, or builds it from keys, read from the
+// buffer when nil. A carried filter must be built with the same keys hash, it is not checked
+// against the keys.
+func (sn *FlatNode[K, VT, V, VList]) snapshotFilter(snapshot *ShardSnapshot[K], vList LeafList[VT, V], keys []K) keyFilter {
+	if sn.conf.FilterFalsePositiveRate <= 0 || sn.conf.hashKey == nil {
+		return nil
+	}
+	if filter := keyFilter(snapshot.Filter); filter.valid() {
+		return filter
+	}
+	if keys == nil {
+		keys = sn.listKeys(vList, sn.level)
+	}
+	return sn.buildFilter(len(keys), slices.Values(keys))
+}
+
+// mayContain reports whether key may be in the view, true when it has no filter.
+func (sn *FlatNode[K, VT, V, VList]) mayContain(view *View[K, VT, V, VList], key K) bool {
+	return view.filter == nil || view.filter.mayContain(sn.conf.hashKey(key))
+}
+
+// defaultHashKey returns the key hash used when FlatConfig.HashKey is not set, nil when K is
+// not an integer, a string or a Key. Hashes are stable across processes.
+func defaultHashKey[K comparable]() func(K) uint64 {
+	var hash any
+	switch any(*new(K)).(type) {
+	case int:
+		hash = func(k int) uint64 { return mixHash(uint64(k)) }
+	case int8:
+		hash = func(k int8) uint64 { return mixHash(uint64(k)) }
+	case int16:
+		hash = func(k int16) uint64 { return mixHash(uint64(k)) }
+	case int32:
+		hash = func(k int32) uint64 { return mixHash(uint64(k)) }
+	case int64:
+		hash = func(k int64) uint64 { return mixHash(uint64(k)) }
+	case uint:
+		hash = func(k uint) uint64 { return mixHash(uint64(k)) }
+	case uint8:
+		hash = func(k uint8) uint64 { return mixHash(uint64(k)) }
+	case uint16:
+		hash = func(k uint16) uint64 { return mixHash(uint64(k)) }
+	case uint32:
+		hash = func(k uint32) uint64 { return mixHash(uint64(k)) }
+	case uint64:
+		hash = func(k uint64) uint64 { return mixHash(k) }
+	case string:
+		hash = hashString
+	case Key:
+		hash = func(k Key) uint64 {
+			if k.kind == KeyString {
+				return hashString(k.str)
+			}
+			return mixHash(k.bits ^ uint64(k.kind)<<56)
+		}
+	default:
+		return nil
+	}
+	return hash.(func(K) uint64)
+}
+
+// mixHash is the splitmix64 finalizer.
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// hashString is FNV-1a, mixed so both halves of the hash are usable.
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h = (h ^ uint64(s[i])) * 1099511628211
+	}
+	return mixHash(bits.RotateLeft64(h, 32) ^ uint64(len(s)))
+}
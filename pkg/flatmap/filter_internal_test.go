@@ -0,0 +1,45 @@
+package flatmap
+
+import "testing"
+
+func TestKeyFilterFalsePositiveRate(t *testing.T) {
+	hash := defaultHashKey[int]()
+	for _, rate := range []float64{0.1, 0.01, 0.001} {
+		const n = 20000
+		filter := newKeyFilter(n, rate)
+		for key := range n {
+			filter.add(hash(key))
+		}
+		falsePositives := 0
+		for key := n; key < 2*n; key++ {
+			if filter.mayContain(hash(key)) {
+				falsePositives++
+			}
+		}
+		for key := range n {
+			if !filter.mayContain(hash(key)) {
+				t.Fatalf("rate %v: false negative for %d", rate, key)
+			}
+		}
+		if got := float64(falsePositives) / n; got > 2*rate {
+			t.Errorf("rate %v: false positive rate %v", rate, got)
+		}
+	}
+}
+
+func TestKeyFilterValid(t *testing.T) {
+	for _, test := range []struct {
+		filter keyFilter
+		want   bool
+	}{
+		{nil, false},
+		{keyFilter{3}, false},
+		{keyFilter{0, 0xff}, false},
+		{keyFilter{17, 0xff}, false},
+		{newKeyFilter(10, 0.01), true},
+	} {
+		if got := test.filter.valid(); got != test.want {
+			t.Errorf("%v.valid() = %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
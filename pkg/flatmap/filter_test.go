@@ -0,0 +1,100 @@
+package flatmap_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func newFilteredConfig(sorted bool) *bookConfig {
+	conf := newBookConfig(1)
+	conf.SortedLayout = sorted
+	conf.CompareKeys = func(a, b int) int { return a - b }
+	conf.FilterFalsePositiveRate = 0.01
+	return conf
+}
+
+func TestFilter(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		conf := newFilteredConfig(sorted)
+		if err := conf.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		node := flatmap.NewFlatNode(conf, 0)
+		var deltas []flatmap.DeltaItem[int]
+		for id := uint64(1); id <= 5000; id++ {
+			deltas = append(deltas, bookDelta(1, id*2, id, 0))
+		}
+		node.FeedDeltaBulk(deltas)
+		book := &books.Book{}
+		for id := 1; id <= 5000; id++ {
+			if !node.Get([]int{id * 2}, book) || !node.Has([]int{id * 2}) {
+				t.Fatalf("sorted %v: %d is filtered out", sorted, id*2)
+			}
+		}
+		snapshot := node.GetSnapshot([]int{2}, true)
+		if len(snapshot.Filter) == 0 {
+			t.Fatalf("sorted %v: the snapshot has no filter", sorted)
+		}
+
+		// consumers load the filter of the snapshot, or rebuild it when it is missing
+		for _, keep := range []bool{true, false} {
+			restored := *snapshot
+			restored.Path = []int{}
+			if !keep {
+				restored.Filter = nil
+			}
+			consumer := flatmap.NewFlatNode(newFilteredConfig(sorted), 0)
+			consumer.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{&restored})
+			consumer.Update(nil)
+			for id := 1; id <= 5000; id++ {
+				if !consumer.Get([]int{id * 2}, book) || consumer.Get([]int{id*2 + 1}, book) {
+					t.Fatalf("sorted %v, filter kept %v: wrong lookup of %d or %d", sorted, keep, id*2, id*2+1)
+				}
+			}
+			if got := consumer.GetSnapshot([]int{2}, false); !bytes.Equal(got.Filter, snapshot.Filter) {
+				t.Fatalf("sorted %v, filter kept %v: the consumer filter differs", sorted, keep)
+			}
+		}
+	}
+}
+
+func TestFilterAfterDelete(t *testing.T) {
+	node := flatmap.NewFlatNode(newFilteredConfig(false), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 1, 0), bookDelta(1, 2, 2, 0)})
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{{Keys: []int{1}, Delete: true}, bookDelta(1, 3, 3, 0)})
+	if node.Has([]int{1}) || !node.Has([]int{2}) || !node.Has([]int{3}) {
+		t.Fatal("the filter was not rebuilt with the shard")
+	}
+}
+
+func TestFilterValidation(t *testing.T) {
+	type pair struct{ a, b int }
+	pairs := &flatmap.FlatConfig[pair, *books.BookT, *books.Book, *books.BookList]{
+		NewV:                    func() *books.Book { return &books.Book{} },
+		GetKeysFromV:            func(*books.Book) []pair { return nil },
+		FilterFalsePositiveRate: 0.01,
+	}
+	if err := pairs.Validate(); err == nil {
+		t.Error("Validate accepted a filter of struct keys without HashKey")
+	}
+	pairs.HashKey = func(p pair) uint64 { return uint64(p.a)<<32 | uint64(p.b) }
+	if err := pairs.Validate(); err != nil {
+		t.Error(err)
+	}
+	pairs.FilterFalsePositiveRate = 1
+	if err := pairs.Validate(); err == nil {
+		t.Error("Validate accepted a false positive rate of 1")
+	}
+
+	keys := &flatmap.FlatConfig[flatmap.Key, *books.BookT, *books.Book, *books.BookList]{
+		NewV:                    func() *books.Book { return &books.Book{} },
+		GetKeysFromV:            func(*books.Book) []flatmap.Key { return nil },
+		FilterFalsePositiveRate: 0.01,
+	}
+	if err := keys.Validate(); err != nil {
+		t.Error(err)
+	}
+}
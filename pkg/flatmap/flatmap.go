@@ -2,6 +2,7 @@ package flatmap
 
 import (
 	"sync"
+	"sync/atomic"
 
 	flatbuffers "github.com/google/flatbuffers/go"
 )
@@ -14,8 +15,28 @@ const (
 )
 
 type View[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
-	indexes map[K]int
-	Vlist   VList // Reference to decoded list for faster access
+	indexes KeyIndex[K]     // nil with FlatConfig.SortedLayout, see lookup
+	Vlist   LeafList[VT, V] // the children of the leaf
+
+	// Per-key versions, only allocated when versioned deltas are used without GetVersionFromV
+	versions   map[K]uint64
+	maxVersion uint64
+	// Versions of the keys removed by versioned deletes, so older writes stay stale
+	tombstones map[K]uint64
+	// Version of the restored snapshot, the least version of the keys nothing else records
+	floorVersion uint64
+
+	// Secondary index name -> indexed value -> children positions, see FlatConfig.Indexes
+	secondary map[string]map[any][]int
+	// Keys sorted with FlatConfig.CompareKeys, nil when it is not set
+	sorted []K
+	// Bloom filter of the keys, nil without FlatConfig.FilterFalsePositiveRate
+	filter keyFilter
+
+	list    VList                                 // Vlist as a VList, returned by GetBatch
+	buffer  []byte                                // the buffer Vlist reads from
+	version uint64                                // tree version the view was published with
+	prev    atomic.Pointer[View[K, VT, V, VList]] // kept while a reader may still use it
 }
 
 // FlatNode represents a node in the sharded map/tree structure.
@@ -29,18 +50,20 @@ type FlatNode[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] stru
 	// Children in the shard tree keyed by hashes - only allocated for non-leaf nodes
 	children map[K]*FlatNode[K, VT, V, VList]
 
-	// Triple buffer containing read/write/backup buffers
+	// Each rebuild writes a new read buffer, the write and backup buffers hold the copies
+	// MutateInPlace alternates between
 	ReadBuffer   []byte // Stores current data for reading
-	WriteBuffer  []byte // Buffer for building new content
-	BackupBuffer []byte // Used for rotation to minimize allocations
+	WriteBuffer  []byte // Buffer the next mutation copies the shard to
+	BackupBuffer []byte // Buffer of the last mutation, reused by the one after next
 	Builder      *flatbuffers.Builder
 
 	// Metadata for reads, e.g. storing offsets/sizes of items
-	viewPtr *View[K, VT, V, VList]
+	viewPtr atomic.Pointer[View[K, VT, V, VList]] // never nil, read without the leaf lock
 
 	// Use pointer for slices that may be empty much of the time
-	pendingDelta []DeltaItem[K]
-	pendingKeys  map[K]struct{}
+	pendingDelta    []DeltaItem[K]
+	pendingKeys     map[K]struct{}
+	pendingDeferred []deferredDelta[K, VT, V]
 
 	initializationWG *sync.WaitGroup
 
@@ -53,6 +76,12 @@ type FlatNode[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] stru
 	// For deleted entries tracking
 	deleted map[K]struct{}
 
+	// Number of versioned deltas dropped because a newer version was already applied
+	staleDeltas atomic.Uint64
+
+	// Tree version of the batch this leaf is taking part in, 0 outside batches
+	batchVersion uint64
+
 	conf *FlatConfig[K, VT, V, VList]
 }
 
@@ -69,15 +98,34 @@ func NewFlatNode[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]](
 		pendingDelta:     make([]DeltaItem[K], 0, 16), // Provide initial capacity
 		pendingKeys:      make(map[K]struct{}, 16),    // Provide initial capacity
 		// Initialize the builder with a default size
-
-		// Allocate maps lazily when they're needed
-		viewPtr: &View[K, VT, V, VList]{
-			indexes: make(map[K]int, 16), // Provide initial capacity
-		},
 	}
+	// Allocate maps lazily when they're needed
+	sn.viewPtr.Store(&View[K, VT, V, VList]{
+		indexes: mapKeyIndex[K]{},
+	})
 	if conf.Logger == nil {
 		conf.Logger = &noLogger{}
 	}
+	if conf.clock == nil {
+		conf.clock = &treeClock{}
+	}
+	if conf.keyBuffers == nil {
+		conf.keyBuffers = &sync.Pool{New: func() any { return new([]K) }}
+	}
+	if conf.probes == nil {
+		conf.probes = &sync.Pool{New: func() any { return conf.NewV() }}
+	}
+	if conf.hashKey == nil && conf.FilterFalsePositiveRate > 0 {
+		conf.hashKey = conf.HashKey
+		if conf.hashKey == nil {
+			conf.hashKey = defaultHashKey[K]()
+		}
+	}
+	if conf.done == nil { // once per tree
+		conf.done = make(chan struct{})
+		conf.closeOnce = &sync.Once{}
+		conf.mergePatch = conf.resolveMergePatch()
+	}
 
 	// Start periodic update in a separate goroutine
 	go sn.PeriodicUpdate()
@@ -106,13 +154,13 @@ func (sn *FlatNode[K, VT, V, VList]) GetRootAsV(buf []byte, x V) {
 
 func (sn *FlatNode[K, VT, V, VList]) GetRootAsVList(buf []byte) VList {
 	n := flatbuffers.GetUOffsetT(buf[0:])
-	x := sn.conf.NewVList()
+	x := sn.newVList()
 	x.Init(buf, n)
 	return x
 }
 
 func (sn *FlatNode[K, VT, V, VList]) VListStart(builder *flatbuffers.Builder) {
-	builder.StartObject(1)
+	builder.StartObject(2)
 }
 
 func (sn *FlatNode[K, VT, V, VList]) End(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
@@ -126,3 +174,9 @@ func (sn *FlatNode[K, VT, V, VList]) VListStartChildrenVector(builder *flatbuffe
 func (sn *FlatNode[K, VT, V, VList]) VListAddChildren(builder *flatbuffers.Builder, children flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(children), 0)
 }
+
+// VListAddKeyIndex adds an encoded key index to the list table, in the field after the
+// children; readers of the list alone skip it.
+func (sn *FlatNode[K, VT, V, VList]) VListAddKeyIndex(builder *flatbuffers.Builder, keyIndex flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(1, keyIndex, 0)
+}
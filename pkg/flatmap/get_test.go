@@ -0,0 +1,60 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestLookupHelpers(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		conf := newBookConfig(2)
+		conf.SortedLayout = sorted
+		conf.CompareKeys = func(a, b int) int { return a - b }
+		conf.GetKeysFromV = nil // a sorted layout reads the keys of the buffer
+		conf.AppendKeysFromV = func(dst []int, b *books.Book) []int {
+			return append(dst, int(b.Id())%10, int(b.Id()))
+		}
+		node := flatmap.NewFlatNode(conf, 0)
+		node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 5, 0), bookDelta(2, 21, 6, 0), bookDelta(2, 12, 7, 0)})
+
+		vt := &books.BookT{ListField: []string{"stale"}}
+		if !node.GetInto([]int{1, 21}, vt) || vt.Id != 21 || vt.PageCount != 6 || vt.Title != "title" || len(vt.ListField) != 0 {
+			t.Errorf("sorted %v: GetInto(1, 21) = %+v", sorted, vt)
+		}
+		if node.GetInto([]int{1, 31}, vt) || vt.Id != 21 {
+			t.Errorf("sorted %v: GetInto of a missing key changed vt to %+v", sorted, vt)
+		}
+
+		for _, test := range []struct {
+			keys []int
+			want bool
+		}{{[]int{2, 12}, true}, {[]int{2, 22}, false}, {[]int{5, 15}, false}, {[]int{1}, false}, {nil, false}} {
+			if got := node.Has(test.keys); got != test.want {
+				t.Errorf("sorted %v: Has(%v) = %v, want %v", sorted, test.keys, got, test.want)
+			}
+		}
+		// sync.Pool drops items under the race detector
+		if allocs := testing.AllocsPerRun(100, func() { node.Has([]int{1, 21}) }); allocs != 0 && !raceEnabled {
+			t.Errorf("sorted %v: Has allocates %v times, want 0", sorted, allocs)
+		}
+
+		fallback := &books.Book{}
+		if got := node.GetOr([]int{1, 99}, &books.Book{}, fallback); got != fallback {
+			t.Errorf("sorted %v: GetOr of a missing key did not return the fallback", sorted)
+		}
+		if got := node.GetOr([]int{1, 11}, &books.Book{}, fallback); got == fallback || got.PageCount() != 5 {
+			t.Errorf("sorted %v: GetOr(1, 11) = %d pages, want 5", sorted, got.PageCount())
+		}
+	}
+}
+
+func TestHasAfterDelete(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 5, 0), bookDelta(1, 2, 6, 0)})
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{{Keys: []int{1}, Delete: true}})
+	if node.Has([]int{1}) || !node.Has([]int{2}) {
+		t.Fatalf("Has(1), Has(2) = %v, %v after deleting 1", node.Has([]int{1}), node.Has([]int{2}))
+	}
+}
@@ -0,0 +1,66 @@
+package flatmap
+
+import (
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// getManyChunk is the least number of key paths GetManyParallel gives a goroutine.
+const getManyChunk = 64
+
+// GetMany looks up many key paths at the committed tree version, calling fn in order with
+// the position of each path in keyPaths. A single V is reused, v is only valid during the call
+// and when ok is true. Consecutive paths of a shard resolve its leaf and view once, so paths
+// sorted by shard skip the walk from the root. The version is not pinned: a shard rebuilt while
+// the call runs may be read at a later version, see ReadTxn.GetMany for one version throughout.
+func (sn *FlatNode[K, VT, V, VList]) GetMany(keyPaths [][]K, fn func(i int, v V, ok bool)) {
+	probe := sn.conf.probes.Get().(V)
+	defer sn.conf.probes.Put(probe)
+	sn.getMany(keyPaths, 0, probe, sn.conf.clock.committed.Load(), fn)
+}
+
+// GetManyParallel is GetMany with keyPaths split into chunks looked up by up to GOMAXPROCS
+// goroutines, all at the same pinned version; fn is called concurrently, in order within a
+// chunk only, and must be safe for it.
+func (sn *FlatNode[K, VT, V, VList]) GetManyParallel(keyPaths [][]K, fn func(i int, v V, ok bool)) {
+	txn := sn.BeginRead()
+	defer txn.Close()
+	workers := min(runtime.GOMAXPROCS(0), (len(keyPaths)+getManyChunk-1)/getManyChunk)
+	if workers <= 1 {
+		sn.getMany(keyPaths, 0, sn.conf.NewV(), txn.version, fn)
+		return
+	}
+	size := (len(keyPaths) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(keyPaths); start += size {
+		end := min(start+size, len(keyPaths))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sn.getMany(keyPaths[start:end], start, sn.conf.NewV(), txn.version, fn)
+		}()
+	}
+	wg.Wait()
+}
+
+// getMany looks up keyPaths at version, reporting them to fn from position offset. The leaf
+// and view of the previous path are kept while the paths share its prefix.
+func (sn *FlatNode[K, VT, V, VList]) getMany(keyPaths [][]K, offset int, v V, version uint64, fn func(i int, v V, ok bool)) {
+	var leaf *FlatNode[K, VT, V, VList]
+	var view *View[K, VT, V, VList]
+	var prefix []K
+	for i, keys := range keyPaths {
+		if leaf == nil || len(keys) <= leaf.level || !slices.Equal(keys[:leaf.level], prefix) {
+			if leaf = sn.leafAt(keys); leaf != nil {
+				view, prefix = leaf.viewAt(version), keys[:leaf.level]
+			}
+		}
+		found := false
+		if leaf != nil {
+			index, ok := leaf.lookup(view, keys[leaf.level], v)
+			found = ok && index >= 0 && view.Vlist.Children(v, index)
+		}
+		fn(offset+i, v, found)
+	}
+}
@@ -0,0 +1,104 @@
+package flatmap_test
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestGetMany(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for _, sorted := range []bool{false, true} {
+		conf := newBookConfig(2)
+		conf.SortedLayout = sorted
+		conf.CompareKeys = func(a, b int) int { return a - b }
+		node := flatmap.NewFlatNode(conf, 0)
+		var deltas []flatmap.DeltaItem[int]
+		for id := uint64(1); id <= 500; id++ {
+			deltas = append(deltas, bookDelta(2, id, id*2, 0))
+		}
+		node.FeedDeltaBulk(deltas)
+
+		// unsorted paths, missing keys and shards, and paths of the wrong length
+		var keyPaths [][]int
+		for i := range 1000 {
+			id := (i*37)%700 + 1
+			keyPaths = append(keyPaths, []int{id % 10, id})
+		}
+		keyPaths = append(keyPaths, []int{3}, nil, []int{42, 1}, []int{1, 11, 0})
+
+		for name, getMany := range map[string]func([][]int, func(int, *books.Book, bool)){
+			"GetMany":         node.GetMany,
+			"GetManyParallel": node.GetManyParallel,
+			"ReadTxn.GetMany": func(keyPaths [][]int, fn func(int, *books.Book, bool)) {
+				txn := node.BeginRead()
+				defer txn.Close()
+				txn.GetMany(keyPaths, fn)
+			},
+		} {
+			got := make([]uint64, len(keyPaths))
+			calls := make([]int, len(keyPaths))
+			var mu sync.Mutex
+			getMany(keyPaths, func(i int, book *books.Book, ok bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls[i]++
+				if ok {
+					got[i] = book.PageCount()
+				}
+			})
+			for i, keys := range keyPaths {
+				want := uint64(0)
+				if len(keys) >= 2 { // Get reads the leaf key and ignores the rest
+					want = pageCount(node, keys)
+				}
+				if calls[i] != 1 || got[i] != want {
+					t.Fatalf("sorted %v, %s: path %d %v called back %d times with %d pages, want once with %d",
+						sorted, name, i, keys, calls[i], got[i], want)
+				}
+			}
+		}
+	}
+}
+
+func TestGetManyCallsBackInOrder(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0), bookDelta(2, 12, 2, 0), bookDelta(2, 21, 3, 0)})
+	keyPaths := [][]int{{2, 12}, {1, 11}, {2, 22}, {1, 21}, {2, 12}}
+	var order []int
+	var pages []uint64
+	node.GetMany(keyPaths, func(i int, book *books.Book, ok bool) {
+		order = append(order, i)
+		if ok {
+			pages = append(pages, book.PageCount())
+		}
+	})
+	if len(order) != 5 || order[0] != 0 || order[4] != 4 || order[2] != 2 {
+		t.Fatalf("GetMany called back with positions %v, want 0 to 4", order)
+	}
+	if len(pages) != 4 || pages[0] != 2 || pages[1] != 1 || pages[2] != 3 || pages[3] != 2 {
+		t.Fatalf("GetMany found %v pages, want [2 1 3 2]", pages)
+	}
+}
+
+func TestGetManyDoesNotAllocate(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0), bookDelta(2, 21, 2, 0), bookDelta(2, 12, 3, 0)})
+	keyPaths := [][]int{{1, 11}, {1, 21}, {2, 12}, {2, 22}, {3, 13}}
+	var found int
+	fn := func(_ int, _ *books.Book, ok bool) {
+		if ok {
+			found++
+		}
+	}
+	// sync.Pool drops items under the race detector
+	if allocs := testing.AllocsPerRun(100, func() { node.GetMany(keyPaths, fn) }); allocs != 0 && !raceEnabled {
+		t.Errorf("GetMany allocates %v times, want 0", allocs)
+	}
+	if found != 3*101 {
+		t.Fatalf("GetMany found %d values in 101 calls, want 3 each", found)
+	}
+}
@@ -0,0 +1,65 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+type (
+	bookConfig = flatmap.FlatConfig[int, *books.BookT, *books.Book, *books.BookList]
+	bookNode   = flatmap.FlatNode[int, *books.BookT, *books.Book, *books.BookList]
+)
+
+// newBookConfig keys books by id, under id%10 with two levels. Updates are only run by the
+// tests, through FeedDeltaBulk or Update.
+func newBookConfig(levels int) *bookConfig {
+	return &bookConfig{
+		UpdateSeconds: 3600,
+		NewV:          func() *books.Book { return &books.Book{} },
+		NewVList:      func() *books.BookList { return &books.BookList{} },
+		GetKeysFromV: func(b *books.Book) []int {
+			return bookKeys(levels, b.Id())
+		},
+	}
+}
+
+func bookKeys(levels int, id uint64) []int {
+	if levels == 2 {
+		return []int{int(id) % 10, int(id)}
+	}
+	return []int{int(id)}
+}
+
+func packBook(book *books.BookT) []byte {
+	builder := flatbuffers.NewBuilder(64)
+	builder.Finish(book.Pack(builder))
+	return builder.FinishedBytes()
+}
+
+// bookDelta sets the book id with pageCount pages, versioned unless version is 0.
+func bookDelta(levels int, id, pageCount, version uint64) flatmap.DeltaItem[int] {
+	return flatmap.DeltaItem[int]{
+		Keys:    bookKeys(levels, id),
+		Data:    packBook(&books.BookT{Id: id, Title: "title", PageCount: pageCount}),
+		Version: version,
+	}
+}
+
+// pageCount returns the page count of the book at keys, 0 when it is missing.
+func pageCount(node *bookNode, keys []int) uint64 {
+	book := &books.Book{}
+	if !node.Get(keys, book) {
+		return 0
+	}
+	return book.PageCount()
+}
+
+func expectPageCount(t *testing.T, node *bookNode, keys []int, want uint64) {
+	t.Helper()
+	if got := pageCount(node, keys); got != want {
+		t.Fatalf("page count of %v = %d, want %d", keys, got, want)
+	}
+}
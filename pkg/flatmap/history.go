@@ -0,0 +1,77 @@
+package flatmap
+
+import (
+	"fmt"
+	"slices"
+)
+
+// GetAt retrieves the value keys had at a past tree version. Leaves retain the views they
+// superseded up to FlatConfig.HistoryDepth and HistoryBytes; an error is returned when the
+// version is not committed yet or its view of the leaf was already dropped. A retained view
+// can be dropped while it is read, use BeginRead to pin the current version instead.
+// DO NOT PASS A NIL VALUE
+func (sn *FlatNode[K, VT, V, VList]) GetAt(version uint64, keys []K, v V) (bool, error) {
+	if len(keys) == 0 {
+		return false, fmt.Errorf("no keys provided")
+	}
+	if version > sn.conf.clock.committed.Load() {
+		return false, fmt.Errorf("version %d is not committed", version)
+	}
+	if sn.nodeType == NodeUndecided {
+		return false, nil
+	}
+	if sn.nodeType == NodeNonLeaf {
+		child, ok := sn.children[keys[sn.level]]
+		if !ok {
+			return false, nil
+		}
+		return child.GetAt(version, keys, v)
+	}
+	view, ok := sn.retainedView(version)
+	if !ok {
+		return false, fmt.Errorf("version %d is no longer retained", version)
+	}
+	index, ok := sn.lookup(view, keys[sn.level], v)
+	if !ok {
+		return false, nil
+	}
+	return view.Vlist.Children(v, index), nil
+}
+
+// SnapshotAt returns deep copies of the snapshots of every non-empty shard as of a past tree
+// version, ready for InitializeWithGroupedShardBuffers. It fails if any leaf no longer
+// retains its view of that version.
+func (sn *FlatNode[K, VT, V, VList]) SnapshotAt(version uint64) ([]*ShardSnapshot[K], error) {
+	if version > sn.conf.clock.committed.Load() {
+		return nil, fmt.Errorf("version %d is not committed", version)
+	}
+	var snapshots []*ShardSnapshot[K]
+	err := sn.collectSnapshotsAt(version, nil, &snapshots)
+	return snapshots, err
+}
+
+func (sn *FlatNode[K, VT, V, VList]) collectSnapshotsAt(version uint64, path []K, snapshots *[]*ShardSnapshot[K]) error {
+	switch sn.nodeType {
+	case NodeNonLeaf:
+		for key, child := range sn.children {
+			if err := child.collectSnapshotsAt(version, append(slices.Clip(path), key), snapshots); err != nil {
+				return err
+			}
+		}
+	case NodeLeaf:
+		view, ok := sn.retainedView(version)
+		if !ok {
+			return fmt.Errorf("version %d is no longer retained for path %v", version, path)
+		}
+		if snapshot := sn.viewSnapshot(view, path, true); snapshot != nil {
+			*snapshots = append(*snapshots, snapshot)
+		}
+	}
+	return nil
+}
+
+// retainedView returns the view of the leaf visible at version, if it is still retained.
+func (sn *FlatNode[K, VT, V, VList]) retainedView(version uint64) (*View[K, VT, V, VList], bool) {
+	view := sn.viewAt(version)
+	return view, view.version <= version
+}
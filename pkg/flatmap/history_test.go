@@ -0,0 +1,74 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestGetAt(t *testing.T) {
+	conf := newBookConfig(2)
+	conf.HistoryDepth = 3
+	node := flatmap.NewFlatNode(conf, 0)
+	var versions []uint64
+	for i := uint64(1); i <= 6; i++ {
+		node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, i, 0)})
+		versions = append(versions, node.Version())
+	}
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 12, 1, 0)})
+
+	book := &books.Book{}
+	for i, version := range versions {
+		found, err := node.GetAt(version, []int{1, 11}, book)
+		switch {
+		case i < 2: // older than the three superseded views the leaf retains
+			if err == nil {
+				t.Fatalf("GetAt(%d) succeeded on an evicted version", version)
+			}
+		case err != nil || !found || book.PageCount() != uint64(i+1):
+			t.Fatalf("GetAt(%d) = %v, %v with %d pages, want %d", version, found, err, book.PageCount(), i+1)
+		}
+	}
+	if found, err := node.GetAt(versions[5], []int{2, 12}, book); found || err != nil {
+		t.Fatalf("GetAt before book 12 was written = %v, %v", found, err)
+	}
+	if _, err := node.GetAt(node.Version()+1, []int{1, 11}, book); err == nil {
+		t.Fatal("GetAt succeeded on an uncommitted version")
+	}
+}
+
+func TestSnapshotAt(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.HistoryDepth = 2
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 1, 0), bookDelta(1, 2, 1, 0)})
+	version := node.Version()
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 2, 0), {Keys: []int{2}, Delete: true}})
+
+	snapshots, err := node.SnapshotAt(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("SnapshotAt returned %d snapshots, want 1", len(snapshots))
+	}
+	restored := flatmap.NewFlatNode(newBookConfig(1), 0)
+	restored.InitializeWithGroupedShardBuffers(snapshots)
+	restored.Update(nil)
+	expectPageCount(t, restored, []int{1}, 1)
+	expectPageCount(t, restored, []int{2}, 1)
+}
+
+func TestHistoryBytes(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.HistoryDepth = 10
+	conf.HistoryBytes = 1 // smaller than any view
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 1, 0)})
+	version := node.Version()
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 2, 0)})
+	if _, err := node.GetAt(version, []int{1}, &books.Book{}); err == nil {
+		t.Fatal("GetAt succeeded on a view beyond HistoryBytes")
+	}
+}
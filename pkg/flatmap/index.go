@@ -0,0 +1,102 @@
+package flatmap
+
+import "fmt"
+
+// IndexDef is a named secondary index over the values of a map, created with NewIndex.
+type IndexDef[V any] struct {
+	Name string
+	keys func(v V) []any
+}
+
+// NewIndex defines a secondary index: every value is indexed under each of the keys
+// returned by keys, e.g. a title or a status. Indexes are rebuilt with each shard view.
+func NewIndex[IK comparable, V any](name string, keys func(v V) []IK) IndexDef[V] {
+	return IndexDef[V]{
+		Name: name,
+		keys: func(v V) []any {
+			indexKeys := keys(v)
+			erased := make([]any, len(indexKeys))
+			for i, key := range indexKeys {
+				erased[i] = key
+			}
+			return erased
+		},
+	}
+}
+
+// GetBy returns the values indexed under value by the named secondary index, across all
+// the shards. value must have the exact type returned by the index definition, an int64
+// index does not match an int value.
+func (sn *FlatNode[K, VT, V, VList]) GetBy(indexName string, value any) ([]V, error) {
+	return sn.getByAt(indexName, value, sn.conf.clock.committed.Load())
+}
+
+func (sn *FlatNode[K, VT, V, VList]) getByAt(indexName string, value any, version uint64) ([]V, error) {
+	if !sn.hasIndex(indexName) {
+		return nil, fmt.Errorf("index %q is not defined", indexName)
+	}
+	var values []V
+	sn.collectIndexed(indexName, value, version, &values)
+	return values, nil
+}
+
+func (sn *FlatNode[K, VT, V, VList]) hasIndex(indexName string) bool {
+	for _, index := range sn.conf.Indexes {
+		if index.Name == indexName {
+			return true
+		}
+	}
+	return false
+}
+
+func (sn *FlatNode[K, VT, V, VList]) collectIndexed(indexName string, value any, version uint64, values *[]V) {
+	switch sn.nodeType {
+	case NodeNonLeaf:
+		for _, child := range sn.children {
+			child.collectIndexed(indexName, value, version, values)
+		}
+	case NodeLeaf:
+		view := sn.viewAt(version)
+		for _, i := range view.secondary[indexName][value] {
+			v := sn.conf.NewV()
+			if view.Vlist.Children(v, i) {
+				*values = append(*values, v)
+			}
+		}
+	}
+}
+
+// buildSecondaryIndexes indexes the children of a view about to be published.
+func (sn *FlatNode[K, VT, V, VList]) buildSecondaryIndexes(view *View[K, VT, V, VList]) {
+	if len(sn.conf.Indexes) == 0 || view.length() == 0 {
+		return
+	}
+	view.secondary = make(map[string]map[any][]int, len(sn.conf.Indexes))
+	for _, index := range sn.conf.Indexes {
+		view.secondary[index.Name] = make(map[any][]int)
+	}
+	var vObj V = sn.conf.NewV()
+	for i := range view.Vlist.ChildrenLength() {
+		if !view.Vlist.Children(vObj, i) {
+			continue
+		}
+		for _, index := range sn.conf.Indexes {
+			positions := view.secondary[index.Name]
+			for _, key := range index.keys(vObj) {
+				positions[key] = append(positions[key], i)
+			}
+		}
+	}
+}
+
+// indexKeysOf returns the keys v is indexed under, per secondary index.
+func (sn *FlatNode[K, VT, V, VList]) indexKeysOf(v V) [][]any {
+	if len(sn.conf.Indexes) == 0 {
+		return nil
+	}
+	keys := make([][]any, len(sn.conf.Indexes))
+	for i, index := range sn.conf.Indexes {
+		keys[i] = index.keys(v)
+	}
+	return keys
+}
@@ -0,0 +1,64 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestGetBy(t *testing.T) {
+	conf := newBookConfig(2)
+	conf.Indexes = []flatmap.IndexDef[*books.Book]{
+		flatmap.NewIndex("pages", func(b *books.Book) []uint64 { return []uint64{b.PageCount()} }),
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 7, 0), bookDelta(2, 12, 7, 0), bookDelta(2, 21, 3, 0)})
+
+	expectIndexed := func(found []*books.Book, err error, want int) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != want {
+			t.Fatalf("found %d books, want %d", len(found), want)
+		}
+		for _, book := range found {
+			if book.PageCount() != 7 {
+				t.Fatalf("book %d with %d pages is indexed under 7", book.Id(), book.PageCount())
+			}
+		}
+	}
+	found, err := node.GetBy("pages", uint64(7))
+	expectIndexed(found, err, 2)
+	if found, _ := node.GetBy("pages", 7); len(found) != 0 {
+		t.Fatal("an int value matched a uint64 index")
+	}
+	if _, err := node.GetBy("title", "title"); err == nil {
+		t.Fatal("GetBy succeeded on an undefined index")
+	}
+
+	txn := node.BeginRead()
+	defer txn.Close()
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0)})
+	found, err = node.GetBy("pages", uint64(7))
+	expectIndexed(found, err, 1)
+	found, err = txn.GetBy("pages", uint64(7))
+	expectIndexed(found, err, 2)
+}
+
+func TestIndexValidation(t *testing.T) {
+	conf := newBookConfig(1)
+	pages := flatmap.NewIndex("pages", func(b *books.Book) []uint64 { return []uint64{b.PageCount()} })
+	conf.Indexes = []flatmap.IndexDef[*books.Book]{pages, pages}
+	if err := conf.Validate(); err == nil {
+		t.Fatal("Validate accepted an index defined twice")
+	}
+	conf.Indexes = []flatmap.IndexDef[*books.Book]{{Name: "pages"}}
+	if err := conf.Validate(); err == nil {
+		t.Fatal("Validate accepted an index not created with NewIndex")
+	}
+}
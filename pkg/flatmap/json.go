@@ -0,0 +1,203 @@
+package flatmap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ExportJSON writes the entries under prefix, all of them when it is empty, as a JSON array
+// with one entry per line. Entries are read from a single tree version and encoded with
+// FlatConfig.Schema when set, with the object API and its json tags otherwise.
+func (sn *FlatNode[K, VT, V, VList]) ExportJSON(w io.Writer, prefix []K) error {
+	txn := sn.BeginRead()
+	defer txn.Close()
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString("["); err != nil {
+		return err
+	}
+	separator := "\n"
+	var err error
+	txn.Iterate(prefix, func(v V) bool {
+		var data []byte
+		if data, err = sn.marshalV(v); err != nil {
+			return false
+		}
+		if _, err = bw.WriteString(separator); err != nil {
+			return false
+		}
+		_, err = bw.Write(data)
+		separator = ",\n"
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\n]\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (sn *FlatNode[K, VT, V, VList]) marshalV(v V) ([]byte, error) {
+	if sn.conf.Schema == nil {
+		return json.Marshal(v.UnPack())
+	}
+	fields, err := sn.conf.Schema.Project(v.Table())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// ImportJSON decodes entries with DecodeJSON and feeds them to the map.
+func (sn *FlatNode[K, VT, V, VList]) ImportJSON(r io.Reader) error {
+	deltas, err := sn.DecodeJSON(r)
+	if err != nil {
+		return err
+	}
+	sn.FeedDeltaBulk(deltas)
+	return nil
+}
+
+// DecodeJSON decodes a JSON array of entries, or a stream of JSON objects, into the object
+// API type VT and returns one delta per entry, serialized with VT.Pack and keyed with
+// GetKeysFromV. With FlatConfig.Schema set, enums may be given by name as ExportJSON writes
+// them.
+func (sn *FlatNode[K, VT, V, VList]) DecodeJSON(r io.Reader) ([]DeltaItem[K], error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(br)
+	dec.UseNumber() // entries decoded through the schema keep their 64-bit integers
+	array := first == '['
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	vtType := reflect.TypeFor[VT]()
+	if vtType.Kind() != reflect.Pointer {
+		return nil, fmt.Errorf("%s is not a pointer type", vtType)
+	}
+	var deltas []DeltaItem[K]
+	var vObj V = sn.conf.NewV()
+	for {
+		if array && !dec.More() {
+			break
+		}
+		vt := reflect.New(vtType.Elem()).Interface().(VT)
+		if err := sn.decodeEntry(dec, vt); err != nil {
+			if err == io.EOF && !array {
+				break
+			}
+			return nil, fmt.Errorf("entry %d: %w", len(deltas), err)
+		}
+		data := packV(vt)
+		sn.GetRootAsV(data, vObj)
+		deltas = append(deltas, DeltaItem[K]{Keys: sn.appendKeys(nil, vObj), Data: data})
+	}
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return deltas, nil
+}
+
+// decodeEntry decodes the next entry into vt, through FlatConfig.Schema when set.
+func (sn *FlatNode[K, VT, V, VList]) decodeEntry(dec *json.Decoder, vt VT) error {
+	if sn.conf.Schema == nil {
+		return dec.Decode(vt)
+	}
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return err
+	}
+	if err := enumValues(sn.conf.Schema, fields); err != nil {
+		return err
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, vt)
+}
+
+// enumValues replaces the enum names found in the fields of object by their values, in
+// nested tables, structs and vectors too. Union members are left as they are.
+func enumValues(object *SchemaObject, fields map[string]any) error {
+	for _, field := range object.Fields {
+		value, ok := fields[field.Name]
+		if !ok {
+			continue
+		}
+		value, err := enumValuesOf(field.Type.Base, field.Type, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.Name, err)
+		}
+		fields[field.Name] = value
+	}
+	return nil
+}
+
+func enumValuesOf(base BaseType, typ SchemaType, value any) (any, error) {
+	switch {
+	case base == BaseTypeVector || base == BaseTypeArray:
+		items, _ := value.([]any)
+		for i, item := range items {
+			var err error
+			if items[i], err = enumValuesOf(typ.Element, typ, item); err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case base == BaseTypeObj:
+		if fields, ok := value.(map[string]any); ok && typ.Object != nil {
+			return value, enumValues(typ.Object, fields)
+		}
+	case base.IsScalar() && typ.Enum != nil && !typ.Enum.IsUnion:
+		if name, ok := value.(string); ok {
+			return typ.Enum.valueOf(name)
+		}
+	}
+	return value, nil // anything else is checked by the object API decoding
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, br.UnreadByte()
+	}
+}
+
+// MarshalJSON encodes the enum value by name, by number when it is not declared.
+func (e EnumValue) MarshalJSON() ([]byte, error) {
+	if e.Name == "" {
+		return json.Marshal(e.Value)
+	}
+	return json.Marshal(e.Name)
+}
+
+// MarshalJSON encodes every field of the table or struct.
+func (o ObjectValue) MarshalJSON() ([]byte, error) {
+	fields, err := o.Object.Project(o.Table)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
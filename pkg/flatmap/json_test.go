@@ -0,0 +1,106 @@
+package flatmap_test
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+// enumBooks lays books.Book out with enums over its page counts.
+const enumBooks = `
+namespace books;
+enum Pages : ulong { NONE = 0, FEW = 7, MANY = 1000 }
+table Book {
+	id: ulong;
+	title: string;
+	page_count: Pages;
+	rate: double;
+	list_field: [string];
+	scalar_list_field: [Pages];
+}
+`
+
+func TestJSONRoundTrip(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 7, 0), bookDelta(2, 12, 7, 0), bookDelta(2, 21, 3, 0)})
+	var buf bytes.Buffer
+	if err := node.ExportJSON(&buf, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	imported := flatmap.NewFlatNode(newBookConfig(2), 0)
+	if err := imported.ImportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expectPageCount(t, imported, []int{1, 11}, 7)
+	expectPageCount(t, imported, []int{1, 21}, 3)
+	expectPageCount(t, imported, []int{2, 12}, 0) // not under the exported prefix
+
+	buf.Reset()
+	if err := flatmap.NewFlatNode(newBookConfig(2), 0).ExportJSON(&buf, nil); err != nil || buf.String() != "[\n]\n" {
+		t.Fatalf("empty export = %q, %v", buf.String(), err)
+	}
+}
+
+func TestJSONSchemaRoundTrip(t *testing.T) {
+	schema, err := flatmap.ParseSchema(enumBooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := newBookConfig(1)
+	conf.Schema = schema.Object("Book")
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{{
+		Keys: []int{1},
+		Data: packBook(&books.BookT{Id: 1, Title: "t", PageCount: 7, ScalarListField: []uint64{1000, 0, 3}}),
+	}})
+
+	var buf bytes.Buffer
+	if err := node.ExportJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.String()
+	if !strings.Contains(exported, `"page_count":"FEW"`) || !strings.Contains(exported, `"scalar_list_field":["MANY","NONE",3]`) {
+		t.Fatalf("export does not name the enums: %s", exported)
+	}
+	imported := flatmap.NewFlatNode(conf, 0)
+	if err := imported.ImportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	book := &books.Book{}
+	if !imported.Get([]int{1}, book) {
+		t.Fatal("book 1 was not imported")
+	}
+	got := book.UnPack()
+	if got.PageCount != 7 || !slices.Equal(got.ScalarListField, []uint64{1000, 0, 3}) || got.Title != "t" {
+		t.Fatalf("imported %+v", got)
+	}
+
+	if _, err := imported.DecodeJSON(strings.NewReader(`{"id": 2, "page_count": "LOTS"}`)); err == nil {
+		t.Fatal("DecodeJSON accepted an undeclared enum name")
+	}
+	deltas, err := imported.DecodeJSON(strings.NewReader(`{"id": 18446744073709551615, "page_count": 7}`))
+	if err != nil || len(deltas) != 1 || books.GetRootAsBook(deltas[0].Data, 0).Id() != 18446744073709551615 {
+		t.Fatalf("DecodeJSON lost a 64-bit id: %v", err)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	deltas, err := node.DecodeJSON(strings.NewReader(`{"id": 5, "page_count": 9} {"id": 15}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 2 || !slices.Equal(deltas[1].Keys, []int{5, 15}) {
+		t.Fatalf("DecodeJSON of a stream = %v", deltas)
+	}
+	if _, err := node.DecodeJSON(strings.NewReader(`[{"id": "x"}]`)); err == nil {
+		t.Fatal("DecodeJSON accepted a string id")
+	}
+	if deltas, err := node.DecodeJSON(strings.NewReader("  ")); err != nil || len(deltas) != 0 {
+		t.Fatalf("DecodeJSON of nothing = %v, %v", deltas, err)
+	}
+}
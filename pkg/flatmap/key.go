@@ -0,0 +1,161 @@
+package flatmap
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+)
+
+// Key is the key type of trees whose levels have different key types, e.g. a string tenant,
+// a uint64 campaign and an int32 creative:
+//
+//	GetKeysFromV: func(c *Creative) []flatmap.Key {
+//		return []flatmap.Key{
+//			flatmap.StringKey(string(c.Tenant())),
+//			flatmap.UintKey(c.CampaignId()),
+//			flatmap.IntKey(int64(c.Id())),
+//		}
+//	}
+//
+// Keys hold their value as is and are comparable, keys of different kinds are never equal.
+type Key struct {
+	kind KeyKind
+	bits uint64 // Int and Uint
+	str  string // String
+}
+
+// KeyKind is the kind of value a Key holds.
+type KeyKind uint8
+
+const (
+	KeyInvalid KeyKind = iota // the zero Key
+	KeyInt
+	KeyUint
+	KeyString
+)
+
+// IntKey returns the Key of a signed integer.
+func IntKey(v int64) Key {
+	return Key{kind: KeyInt, bits: uint64(v)}
+}
+
+// UintKey returns the Key of an unsigned integer, never equal to the IntKey of the same value.
+func UintKey(v uint64) Key {
+	return Key{kind: KeyUint, bits: v}
+}
+
+// StringKey returns the Key of a string.
+func StringKey(v string) Key {
+	return Key{kind: KeyString, str: v}
+}
+
+// KeyOf returns the Key of an integer, a string, a []byte or a Key.
+func KeyOf(v any) (Key, error) {
+	switch v := v.(type) {
+	case Key:
+		return v, nil
+	case int:
+		return IntKey(int64(v)), nil
+	case int8:
+		return IntKey(int64(v)), nil
+	case int16:
+		return IntKey(int64(v)), nil
+	case int32:
+		return IntKey(int64(v)), nil
+	case int64:
+		return IntKey(v), nil
+	case uint:
+		return UintKey(uint64(v)), nil
+	case uint8:
+		return UintKey(uint64(v)), nil
+	case uint16:
+		return UintKey(uint64(v)), nil
+	case uint32:
+		return UintKey(uint64(v)), nil
+	case uint64:
+		return UintKey(v), nil
+	case string:
+		return StringKey(v), nil
+	case []byte:
+		return StringKey(string(v)), nil
+	}
+	return Key{}, fmt.Errorf("%T cannot be a key", v)
+}
+
+// KeyPath returns the keys of values, see KeyOf. It panics on values that cannot be keys,
+// it is meant for literal paths such as KeyPath("acme", uint64(7)).
+func KeyPath(values ...any) []Key {
+	keys := make([]Key, len(values))
+	for i, v := range values {
+		key, err := KeyOf(v)
+		if err != nil {
+			panic(err)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+func (k Key) Kind() KeyKind {
+	return k.kind
+}
+
+// Int returns the value of an Int key, 0 for other kinds.
+func (k Key) Int() int64 {
+	if k.kind != KeyInt {
+		return 0
+	}
+	return int64(k.bits)
+}
+
+// Uint returns the value of a Uint key, 0 for other kinds.
+func (k Key) Uint() uint64 {
+	if k.kind != KeyUint {
+		return 0
+	}
+	return k.bits
+}
+
+// Str returns the value of a String key, "" for other kinds.
+func (k Key) Str() string {
+	return k.str
+}
+
+// Value returns the value of the key as an int64, a uint64 or a string, nil when invalid.
+func (k Key) Value() any {
+	switch k.kind {
+	case KeyInt:
+		return k.Int()
+	case KeyUint:
+		return k.bits
+	case KeyString:
+		return k.str
+	}
+	return nil
+}
+
+func (k Key) String() string {
+	switch k.kind {
+	case KeyInt:
+		return strconv.FormatInt(k.Int(), 10)
+	case KeyUint:
+		return strconv.FormatUint(k.bits, 10)
+	case KeyString:
+		return k.str
+	}
+	return "<invalid>"
+}
+
+// CompareKey orders keys by kind, then by value; it can be used as FlatConfig.CompareKeys.
+func CompareKey(a, b Key) int {
+	if c := cmp.Compare(a.kind, b.kind); c != 0 {
+		return c
+	}
+	switch a.kind {
+	case KeyInt:
+		return cmp.Compare(a.Int(), b.Int())
+	case KeyUint:
+		return cmp.Compare(a.bits, b.bits)
+	}
+	return cmp.Compare(a.str, b.str)
+}
@@ -0,0 +1,71 @@
+package flatmap_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestKey(t *testing.T) {
+	if flatmap.IntKey(1) == flatmap.UintKey(1) {
+		t.Error("IntKey(1) == UintKey(1)")
+	}
+	if flatmap.StringKey("a") != flatmap.StringKey("a") {
+		t.Error(`StringKey("a") != StringKey("a")`)
+	}
+	if flatmap.CompareKey(flatmap.IntKey(-1), flatmap.IntKey(2)) >= 0 {
+		t.Error("CompareKey(IntKey(-1), IntKey(2)) >= 0")
+	}
+	if flatmap.CompareKey(flatmap.UintKey(9), flatmap.StringKey("0")) >= 0 {
+		t.Error("CompareKey does not order keys by kind first")
+	}
+	if key := flatmap.KeyPath(int32(-3))[0]; key.Kind() != flatmap.KeyInt || key.Int() != -3 || key.Value() != int64(-3) {
+		t.Errorf("KeyPath(int32(-3)) = %v", key)
+	}
+	if got := fmt.Sprint(flatmap.KeyPath("a", uint8(3), []byte("b"))); got != "[a 3 b]" {
+		t.Errorf(`KeyPath("a", uint8(3), []byte("b")) = %s`, got)
+	}
+	if _, err := flatmap.KeyOf(1.5); err == nil {
+		t.Error("KeyOf(1.5) returned no error")
+	}
+	if got := fmt.Sprint(flatmap.Key{}); got != "<invalid>" {
+		t.Errorf("Key{} prints as %s", got)
+	}
+}
+
+func TestCompositeKeyTree(t *testing.T) {
+	schema, err := flatmap.LoadSchema("../../example/books.fbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := &flatmap.Loader[flatmap.Key]{Schema: schema.Object("Book"), KeyFields: []string{"title", "id"}}
+	if err := loader.LoadCSV(strings.NewReader("id,title,page_count\n1,a,5\n2,a,6\n1,b,7\n")); err != nil {
+		t.Fatal(err)
+	}
+	snapshots := loader.Snapshots()
+	if len(snapshots) != 2 || snapshots[0].Path[0] != flatmap.StringKey("a") || snapshots[0].Keys[1] != flatmap.UintKey(2) {
+		t.Fatalf("Snapshots() = %+v", snapshots)
+	}
+
+	node := flatmap.NewFlatNode(&flatmap.FlatConfig[flatmap.Key, *books.BookT, *books.Book, *books.BookList]{
+		UpdateSeconds: 3600,
+		NewV:          func() *books.Book { return &books.Book{} },
+		GetKeysFromV: func(b *books.Book) []flatmap.Key {
+			return flatmap.KeyPath(b.Title(), b.Id())
+		},
+	}, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[flatmap.Key]{
+		{Keys: flatmap.KeyPath("a", uint64(1)), Data: packBook(&books.BookT{Id: 1, Title: "a", PageCount: 5})},
+		{Keys: flatmap.KeyPath("b", uint64(1)), Data: packBook(&books.BookT{Id: 1, Title: "b", PageCount: 7})},
+	})
+	book := &books.Book{}
+	if !node.Get(flatmap.KeyPath("b", uint64(1)), book) || book.PageCount() != 7 {
+		t.Fatalf("Get(b, 1) = %v, want page count 7", book.PageCount())
+	}
+	if node.Get(flatmap.KeyPath("b", int64(1)), book) {
+		t.Fatal("Get(b, IntKey(1)) found the entry of UintKey(1)")
+	}
+}
@@ -0,0 +1,302 @@
+package flatmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// KeyIndex maps the keys of a leaf to the positions of their children in the buffer, see
+// FlatConfig.NewKeyIndex. An index is built once per rebuild and only read afterwards, from
+// any number of goroutines.
+type KeyIndex[K comparable] interface {
+	Lookup(key K) (int, bool)
+	Len() int
+	// AppendKeys appends the keys to dst in the order of their positions.
+	AppendKeys(dst []K) []K
+}
+
+// EncodedKeyIndex is a KeyIndex held in a single []byte. With FlatConfig.OpenKeyIndex, leaves
+// embed the bytes in their buffer and read the index from it in place.
+type EncodedKeyIndex interface {
+	Bytes() []byte
+}
+
+// IntegerKey is the key constraint of IntKeyIndex.
+type IntegerKey interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// mapKeyIndex is the default KeyIndex, the map built by the rebuild.
+type mapKeyIndex[K comparable] map[K]int
+
+func (m mapKeyIndex[K]) Lookup(key K) (int, bool) {
+	index, ok := m[key]
+	return index, ok
+}
+
+func (m mapKeyIndex[K]) Len() int {
+	return len(m)
+}
+
+func (m mapKeyIndex[K]) AppendKeys(dst []K) []K {
+	start := len(dst)
+	dst = append(dst, make([]K, len(m))...)
+	for k, i := range m {
+		dst[start+i] = k
+	}
+	return dst
+}
+
+// Encoded key indexes start with a header: the kind, then the number of keys and of slots
+// as little endian uint32 at 4 and 8. The slots follow, two little endian uint64 each, then
+// the key bytes of a string index. Slots are probed linearly from the hash of the key.
+const (
+	keyIndexHeader   = 16
+	keyIndexSlot     = 16
+	keyIndexInt      = 1
+	keyIndexString   = 2
+	keyIndexPosition = 1<<32 - 1 // mask of position+1 in the second word of a slot
+)
+
+// IntKeyIndex is an open addressing KeyIndex of integer keys held in a single []byte, so it
+// holds no pointers and costs the garbage collector nothing to scan, unlike a map.
+type IntKeyIndex[K IntegerKey] struct {
+	data []byte // key, position+1 slots, position 0 marks an empty slot
+	mask uint64
+	n    int
+}
+
+// NewIntKeyIndex returns the index of keys, the key at i having position i. It can be used
+// as FlatConfig.NewKeyIndex.
+func NewIntKeyIndex[K IntegerKey](keys []K) KeyIndex[K] {
+	index := &IntKeyIndex[K]{}
+	index.data, index.mask = newKeyIndexData(keyIndexInt, len(keys), 0)
+	for i, key := range keys {
+		slot, found := index.find(key)
+		if !found {
+			index.n++
+		}
+		binary.LittleEndian.PutUint64(index.data[slot:], uint64(key))
+		binary.LittleEndian.PutUint64(index.data[slot+8:], uint64(i)+1)
+	}
+	binary.LittleEndian.PutUint32(index.data[4:], uint32(index.n))
+	return index
+}
+
+// OpenIntKeyIndex reads an index encoded by NewIntKeyIndex, data is used in place. It can be
+// used as FlatConfig.OpenKeyIndex.
+func OpenIntKeyIndex[K IntegerKey](data []byte) (KeyIndex[K], error) {
+	n, mask, err := openKeyIndexData(data, keyIndexInt)
+	if err != nil {
+		return nil, err
+	}
+	return &IntKeyIndex[K]{data: data, mask: mask, n: n}, nil
+}
+
+// find returns the position in data of the slot of key and whether it holds it, the slot is
+// otherwise the empty one key would take.
+func (idx *IntKeyIndex[K]) find(key K) (int, bool) {
+	hash := mixHash(uint64(key))
+	for probe := uint64(0); probe <= idx.mask; probe++ {
+		slot := keyIndexHeader + int((hash+probe)&idx.mask)*keyIndexSlot
+		if binary.LittleEndian.Uint64(idx.data[slot+8:]) == 0 {
+			return slot, false
+		}
+		if binary.LittleEndian.Uint64(idx.data[slot:]) == uint64(key) {
+			return slot, true
+		}
+	}
+	return keyIndexHeader, false // full, only when the data is corrupt
+}
+
+func (idx *IntKeyIndex[K]) Lookup(key K) (int, bool) {
+	slot, found := idx.find(key)
+	if !found {
+		return 0, false
+	}
+	return int(binary.LittleEndian.Uint64(idx.data[slot+8:])) - 1, true
+}
+
+func (idx *IntKeyIndex[K]) Len() int {
+	return idx.n
+}
+
+func (idx *IntKeyIndex[K]) AppendKeys(dst []K) []K {
+	start := len(dst)
+	dst = append(dst, make([]K, idx.n)...)
+	for slot := keyIndexHeader; slot < keyIndexHeader+int(idx.mask+1)*keyIndexSlot; slot += keyIndexSlot {
+		position := int(binary.LittleEndian.Uint64(idx.data[slot+8:]))
+		if position > 0 && position <= idx.n {
+			dst[start+position-1] = K(binary.LittleEndian.Uint64(idx.data[slot:]))
+		}
+	}
+	return dst
+}
+
+func (idx *IntKeyIndex[K]) Bytes() []byte {
+	return idx.data
+}
+
+// StringKeyIndex is an open addressing KeyIndex of string keys held in a single []byte, the
+// slots followed by the key bytes, so it holds no pointers and no string per key.
+type StringKeyIndex[K ~string] struct {
+	data []byte // offset<<32 | length, hash<<32 | position+1 slots, then the key bytes
+	keys []byte // the key bytes, one key after another
+	mask uint64
+	n    int
+}
+
+// NewStringKeyIndex returns the index of keys, the key at i having position i. Keys are
+// limited to 4GB in total. It can be used as FlatConfig.NewKeyIndex.
+func NewStringKeyIndex[K ~string](keys []K) KeyIndex[K] {
+	total := 0
+	for _, key := range keys {
+		total += len(key)
+	}
+	index := &StringKeyIndex[K]{}
+	index.data, index.mask = newKeyIndexData(keyIndexString, len(keys), total)
+	index.keys = index.data[len(index.data):]
+	for i, key := range keys {
+		hash := hashString(string(key))
+		slot, found := index.find(key, hash)
+		if !found {
+			index.n++
+			binary.LittleEndian.PutUint64(index.data[slot:], uint64(len(index.keys))<<32|uint64(len(key)))
+			index.keys = append(index.keys, key...)
+		}
+		binary.LittleEndian.PutUint64(index.data[slot+8:], hash&^keyIndexPosition|(uint64(i)+1))
+	}
+	index.data = index.data[:len(index.data)+len(index.keys)]
+	binary.LittleEndian.PutUint32(index.data[4:], uint32(index.n))
+	return index
+}
+
+// OpenStringKeyIndex reads an index encoded by NewStringKeyIndex, data is used in place. It
+// can be used as FlatConfig.OpenKeyIndex.
+func OpenStringKeyIndex[K ~string](data []byte) (KeyIndex[K], error) {
+	n, mask, err := openKeyIndexData(data, keyIndexString)
+	if err != nil {
+		return nil, err
+	}
+	keys := data[keyIndexHeader+int(mask+1)*keyIndexSlot:]
+	return &StringKeyIndex[K]{data: data, keys: keys, mask: mask, n: n}, nil
+}
+
+// find returns the position in data of the slot of key and whether it holds it, the slot is
+// otherwise the empty one key would take.
+func (idx *StringKeyIndex[K]) find(key K, hash uint64) (int, bool) {
+	for probe := uint64(0); probe <= idx.mask; probe++ {
+		slot := keyIndexHeader + int((hash+probe)&idx.mask)*keyIndexSlot
+		entry := binary.LittleEndian.Uint64(idx.data[slot+8:])
+		if entry == 0 {
+			return slot, false
+		}
+		if entry>>32 == hash>>32 && string(idx.key(slot)) == string(key) {
+			return slot, true
+		}
+	}
+	return keyIndexHeader, false // full, only when the data is corrupt
+}
+
+// key returns the bytes of the key held by a used slot, nil when they are out of range.
+func (idx *StringKeyIndex[K]) key(slot int) []byte {
+	span := binary.LittleEndian.Uint64(idx.data[slot:])
+	offset, length := span>>32, span&keyIndexPosition
+	if offset+length > uint64(len(idx.keys)) {
+		return nil
+	}
+	return idx.keys[offset : offset+length]
+}
+
+func (idx *StringKeyIndex[K]) Lookup(key K) (int, bool) {
+	slot, found := idx.find(key, hashString(string(key)))
+	if !found {
+		return 0, false
+	}
+	return int(binary.LittleEndian.Uint64(idx.data[slot+8:])&keyIndexPosition) - 1, true
+}
+
+func (idx *StringKeyIndex[K]) Len() int {
+	return idx.n
+}
+
+func (idx *StringKeyIndex[K]) AppendKeys(dst []K) []K {
+	start := len(dst)
+	dst = append(dst, make([]K, idx.n)...)
+	for slot := keyIndexHeader; slot < keyIndexHeader+int(idx.mask+1)*keyIndexSlot; slot += keyIndexSlot {
+		position := int(binary.LittleEndian.Uint64(idx.data[slot+8:]) & keyIndexPosition)
+		if position > 0 && position <= idx.n {
+			dst[start+position-1] = K(idx.key(slot))
+		}
+	}
+	return dst
+}
+
+func (idx *StringKeyIndex[K]) Bytes() []byte {
+	return idx.data
+}
+
+// newKeyIndexData returns the empty encoded index of n keys with room for extra bytes after
+// the slots, and the mask of its slot numbers. Slots are at most half full.
+func newKeyIndexData(kind byte, n, extra int) ([]byte, uint64) {
+	slots := 1 << bits.Len(uint(max(2*n, 1)))
+	size := keyIndexHeader + slots*keyIndexSlot
+	data := make([]byte, size, size+extra)
+	data[0] = kind
+	binary.LittleEndian.PutUint32(data[8:], uint32(slots))
+	return data, uint64(slots - 1)
+}
+
+// openKeyIndexData checks the header of an encoded index, it returns its number of keys and
+// the mask of its slot numbers.
+func openKeyIndexData(data []byte, kind byte) (int, uint64, error) {
+	if len(data) < keyIndexHeader || data[0] != kind {
+		return 0, 0, fmt.Errorf("not an encoded key index of kind %d", kind)
+	}
+	n := binary.LittleEndian.Uint32(data[4:])
+	slots := uint64(binary.LittleEndian.Uint32(data[8:]))
+	if slots == 0 || slots&(slots-1) != 0 || uint64(n) >= slots || uint64(len(data)) < keyIndexHeader+slots*keyIndexSlot {
+		return 0, 0, fmt.Errorf("corrupt key index: %d keys, %d slots, %d bytes", n, slots, len(data))
+	}
+	return int(n), slots - 1, nil
+}
+
+// keyIndex returns the index of the keys of a rebuilt leaf, built with FlatConfig.NewKeyIndex
+// or the map itself.
+func (sn *FlatNode[K, VT, V, VList]) keyIndex(indexes map[K]int) KeyIndex[K] {
+	if sn.conf.NewKeyIndex == nil {
+		return mapKeyIndex[K](indexes)
+	}
+	return sn.conf.NewKeyIndex(mapKeyIndex[K](indexes).AppendKeys(nil))
+}
+
+// keyIndexOf returns the index of keys in buffer order.
+func (sn *FlatNode[K, VT, V, VList]) keyIndexOf(keys []K) KeyIndex[K] {
+	if sn.conf.NewKeyIndex != nil {
+		return sn.conf.NewKeyIndex(keys)
+	}
+	indexes := make(map[K]int, len(keys))
+	for i, k := range keys {
+		indexes[k] = i
+	}
+	return mapKeyIndex[K](indexes)
+}
+
+// embeddedKeyIndex returns the index embedded in a leaf buffer, nil when it has none or
+// FlatConfig.OpenKeyIndex is not set.
+func (sn *FlatNode[K, VT, V, VList]) embeddedKeyIndex(buf []byte) KeyIndex[K] {
+	if sn.conf.OpenKeyIndex == nil || sn.conf.SortedLayout {
+		return nil
+	}
+	data := leafKeyIndex(buf)
+	if data == nil {
+		return nil
+	}
+	index, err := sn.conf.OpenKeyIndex(data)
+	if err != nil {
+		sn.logf(WarnLevel, "%s level %d: ignoring the embedded key index: %v\n", sn.conf.Name, sn.level, err)
+		return nil
+	}
+	return index
+}
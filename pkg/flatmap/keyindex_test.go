@@ -0,0 +1,240 @@
+package flatmap_test
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestIntKeyIndex(t *testing.T) {
+	index := flatmap.NewIntKeyIndex([]int{5, -3, 0, 1 << 40, 7, 5})
+	if index.Len() != 5 {
+		t.Fatalf("Len() = %d, want 5", index.Len())
+	}
+	for key, want := range map[int]int{5: 5, -3: 1, 0: 2, 1 << 40: 3, 7: 4} {
+		if i, ok := index.Lookup(key); !ok || i != want {
+			t.Errorf("Lookup(%d) = %d, %v, want %d", key, i, ok, want)
+		}
+	}
+	if _, ok := index.Lookup(9); ok {
+		t.Error("Lookup(9) found a missing key")
+	}
+
+	keys := make([]int, 100000)
+	for i := range keys {
+		keys[i] = i * 31
+	}
+	if got := flatmap.NewIntKeyIndex(keys).AppendKeys(nil); !slices.Equal(got, keys) {
+		t.Error("AppendKeys does not return the keys in order")
+	}
+}
+
+func TestStringKeyIndex(t *testing.T) {
+	keys := []string{"a", "", "bb", "ccc"}
+	index := flatmap.NewStringKeyIndex(keys)
+	for want, key := range keys {
+		if i, ok := index.Lookup(key); !ok || i != want {
+			t.Errorf("Lookup(%q) = %d, %v, want %d", key, i, ok, want)
+		}
+	}
+	if _, ok := index.Lookup("b"); ok {
+		t.Error(`Lookup("b") found a missing key`)
+	}
+	if got := index.AppendKeys(nil); !slices.Equal(got, keys) {
+		t.Errorf("AppendKeys = %q, want %q", got, keys)
+	}
+}
+
+func TestKeyIndexInTree(t *testing.T) {
+	for _, custom := range []bool{false, true} {
+		conf := newBookConfig(2)
+		if custom {
+			conf.NewKeyIndex = flatmap.NewIntKeyIndex[int]
+		}
+		conf.CompareKeys = func(a, b int) int { return a - b }
+		node := flatmap.NewFlatNode(conf, 0)
+		var deltas []flatmap.DeltaItem[int]
+		for id := uint64(1); id <= 1000; id++ {
+			deltas = append(deltas, bookDelta(2, id, id, 0))
+		}
+		node.FeedDeltaBulk(deltas)
+		node.FeedDeltaBulk([]flatmap.DeltaItem[int]{{Keys: []int{3, 3}, Delete: true}, bookDelta(2, 13, 99, 0)})
+		expectPageCount(t, node, []int{3, 13}, 99)
+		expectPageCount(t, node, []int{3, 3}, 0)
+		expectPageCount(t, node, []int{4, 994}, 994)
+		if !node.Has([]int{5, 5}) {
+			t.Fatalf("custom %v: Has(5, 5) = false", custom)
+		}
+		if keys := collectKeys(node.Ascend(nil), 0); len(keys) != 999 {
+			t.Fatalf("custom %v: Ascend = %d keys, want 999", custom, len(keys))
+		}
+
+		// the index is rebuilt from the keys of a snapshot
+		one := newBookConfig(1)
+		one.NewKeyIndex = conf.NewKeyIndex
+		producer := flatmap.NewFlatNode(one, 0)
+		producer.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 1, 0), bookDelta(1, 2, 2, 0)})
+		snapshot := producer.GetSnapshot([]int{1}, true)
+		snapshot.Path = []int{}
+		consumer := flatmap.NewFlatNode(one, 0)
+		consumer.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+		consumer.Update(nil)
+		expectPageCount(t, consumer, []int{2}, 2)
+		expectPageCount(t, consumer, []int{3}, 0)
+	}
+}
+
+// heapObjects returns how many more heap objects are live once build has run, its result
+// being kept.
+func heapObjects(build func() any) int64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	kept := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(kept)
+	return int64(after.HeapObjects) - int64(before.HeapObjects)
+}
+
+func TestStringKeyIndexIsPointerFree(t *testing.T) {
+	const n = 100000
+	keys := func() []string {
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = fmt.Sprint("key-", i)
+		}
+		return keys
+	}
+	objects := heapObjects(func() any { return flatmap.NewStringKeyIndex(keys()) })
+	if objects > 100 {
+		t.Fatalf("a string index of %d keys keeps %d heap objects", n, objects)
+	}
+}
+
+func newEmbeddedIndexConfig() *bookConfig {
+	conf := newBookConfig(1)
+	conf.NewKeyIndex = flatmap.NewIntKeyIndex[int]
+	conf.OpenKeyIndex = flatmap.OpenIntKeyIndex[int]
+	return conf
+}
+
+func TestEmbeddedKeyIndex(t *testing.T) {
+	conf := newEmbeddedIndexConfig()
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := uint64(1); id <= 1000; id++ {
+		deltas = append(deltas, bookDelta(1, id, id, 0))
+	}
+	node.FeedDeltaBulk(deltas)
+	if ok, err := node.MutateInPlace([]int{7}, func(b *books.Book) bool { return b.MutatePageCount(70) }, nil); !ok || err != nil {
+		t.Fatalf("MutateInPlace = %v, %v", ok, err)
+	}
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 2000, 5, 0)}) // recycles the buffers
+	expectPageCount(t, node, []int{7}, 70)
+	expectPageCount(t, node, []int{2000}, 5)
+	expectPageCount(t, node, []int{1001}, 0)
+
+	snapshot := node.GetSnapshot([]int{1}, true)
+	if snapshot.Keys != nil {
+		t.Fatalf("the snapshot carries %d keys besides its embedded index", len(snapshot.Keys))
+	}
+	snapshot.Path = []int{}
+	plain := newBookConfig(1)
+	plain.NewVList = nil
+	for name, conf := range map[string]*bookConfig{"embedded": newEmbeddedIndexConfig(), "plain": plain} {
+		consumer := flatmap.NewFlatNode(conf, 0)
+		consumer.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+		consumer.Update(nil)
+		for id, want := range map[int]uint64{7: 70, 2000: 5, 999: 999, 1001: 0} {
+			if got := pageCount(consumer, []int{id}); got != want {
+				t.Errorf("%s: page count of %d = %d, want %d", name, id, got, want)
+			}
+		}
+		if list, ok := consumer.GetBatch(nil); !ok || list.ChildrenLength() != 1001 {
+			t.Errorf("%s: GetBatch = %v, want 1001 books", name, ok)
+		}
+	}
+}
+
+func TestSnapshotBufferIsNotWritten(t *testing.T) {
+	producer := flatmap.NewFlatNode(newEmbeddedIndexConfig(), 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := uint64(1); id <= 100; id++ {
+		deltas = append(deltas, bookDelta(1, id, id, 0))
+	}
+	producer.FeedDeltaBulk(deltas)
+	snapshot := producer.GetSnapshot([]int{1}, true)
+	want := slices.Clone(snapshot.Buffer)
+
+	// two maps sharing the buffer, as a read-only mapping of one file would be
+	first, second := flatmap.NewFlatNode(newEmbeddedIndexConfig(), 0), flatmap.NewFlatNode(newEmbeddedIndexConfig(), 0)
+	for _, consumer := range []*bookNode{first, second} {
+		consumer.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+		consumer.Update(nil)
+	}
+	for i := range uint64(5) {
+		first.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1+i, 1000+i, 0)})
+		first.MutateInPlace([]int{50}, func(b *books.Book) bool { return b.MutatePageCount(500 + i) }, nil)
+		second.MutateInPlace([]int{60}, func(b *books.Book) bool { return b.MutatePageCount(600 + i) }, nil)
+	}
+	if !slices.Equal(snapshot.Buffer, want) {
+		t.Fatal("the restored snapshot buffer was written")
+	}
+	expectPageCount(t, first, []int{1}, 1000)
+	expectPageCount(t, first, []int{50}, 504)
+	expectPageCount(t, second, []int{1}, 1)
+	expectPageCount(t, second, []int{60}, 604)
+}
+
+func TestEmbeddedKeyIndexFromOldSnapshot(t *testing.T) {
+	producer := flatmap.NewFlatNode(newBookConfig(1), 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := uint64(1); id <= 1000; id++ {
+		deltas = append(deltas, bookDelta(1, id, id, 0))
+	}
+	producer.FeedDeltaBulk(deltas)
+	snapshot := producer.GetSnapshot([]int{1}, true)
+	snapshot.Path = []int{}
+
+	consumer := flatmap.NewFlatNode(newEmbeddedIndexConfig(), 0)
+	consumer.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+	consumer.Update(nil)
+	expectPageCount(t, consumer, []int{999}, 999)
+	if consumer.GetSnapshot([]int{1}, false).Keys == nil {
+		t.Fatal("a snapshot without an embedded index lost its keys")
+	}
+	consumer.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 3, 33, 0)})
+	expectPageCount(t, consumer, []int{3}, 33)
+	if consumer.GetSnapshot([]int{1}, false).Keys != nil {
+		t.Fatal("the rebuilt leaf does not embed its index")
+	}
+}
+
+func TestOpenKeyIndex(t *testing.T) {
+	keys := []string{"x", "yy", "", "zzz"}
+	encoded := flatmap.NewStringKeyIndex(keys).(flatmap.EncodedKeyIndex).Bytes()
+	reopened, err := flatmap.OpenStringKeyIndex[string](encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.AppendKeys(nil); !slices.Equal(got, keys) {
+		t.Fatalf("AppendKeys of the reopened index = %q, want %q", got, keys)
+	}
+	if i, ok := reopened.Lookup("zzz"); !ok || i != 3 {
+		t.Fatalf(`Lookup("zzz") = %d, %v, want 3`, i, ok)
+	}
+	if _, err := flatmap.OpenIntKeyIndex[int](encoded); err == nil {
+		t.Error("OpenIntKeyIndex opened a string index")
+	}
+	if _, err := flatmap.OpenIntKeyIndex[int]([]byte{1, 0, 0, 0, 9, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Error("OpenIntKeyIndex opened a truncated index")
+	}
+}
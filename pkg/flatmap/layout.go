@@ -0,0 +1,101 @@
+package flatmap
+
+import (
+	"slices"
+	"sort"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// lookup returns the position of key among the children of a leaf view, after checking the
+// filter of the view. With a sorted layout it binary searches the buffer, loading the probed
+// children into probe.
+func (sn *FlatNode[K, VT, V, VList]) lookup(view *View[K, VT, V, VList], key K, probe V) (int, bool) {
+	if !sn.mayContain(view, key) {
+		return 0, false
+	}
+	if !sn.conf.SortedLayout {
+		return view.indexes.Lookup(key)
+	}
+	n := view.length()
+	index := sort.Search(n, func(i int) bool {
+		return sn.conf.CompareKeys(sn.keyAt(view, i, probe), key) >= 0
+	})
+	return index, index < n && sn.conf.CompareKeys(sn.keyAt(view, index, probe), key) == 0
+}
+
+// keyAt returns the key of the child at index, loaded into probe.
+func (sn *FlatNode[K, VT, V, VList]) keyAt(view *View[K, VT, V, VList], index int, probe V) K {
+	view.Vlist.Children(probe, index)
+	return sn.keyOf(probe, sn.level)
+}
+
+// keyOf returns the key of v at level. With AppendKeysFromV the keys are read into a pooled
+// buffer, so it does not allocate.
+func (sn *FlatNode[K, VT, V, VList]) keyOf(v V, level int) K {
+	if sn.conf.AppendKeysFromV == nil {
+		return sn.conf.GetKeysFromV(v)[level]
+	}
+	buf := sn.conf.keyBuffers.Get().(*[]K)
+	*buf = sn.conf.AppendKeysFromV((*buf)[:0], v)
+	key := (*buf)[level]
+	sn.conf.keyBuffers.Put(buf)
+	return key
+}
+
+// appendKeys appends the keys of v to dst.
+func (sn *FlatNode[K, VT, V, VList]) appendKeys(dst []K, v V) []K {
+	if sn.conf.AppendKeysFromV != nil {
+		return sn.conf.AppendKeysFromV(dst, v)
+	}
+	return append(dst, sn.conf.GetKeysFromV(v)...)
+}
+
+// viewKeys returns the keys of a leaf view in the order of the children in the buffer.
+func (sn *FlatNode[K, VT, V, VList]) viewKeys(view *View[K, VT, V, VList]) []K {
+	if sn.conf.SortedLayout {
+		if view.buffer == nil {
+			return nil
+		}
+		return sn.listKeys(view.Vlist, sn.level)
+	}
+	return view.indexes.AppendKeys(make([]K, 0, view.indexes.Len()))
+}
+
+// listKeys reads the keys at level of the children of a list.
+func (sn *FlatNode[K, VT, V, VList]) listKeys(list LeafList[VT, V], level int) []K {
+	keys := make([]K, list.ChildrenLength())
+	var vObj V = sn.conf.NewV()
+	for i := range keys {
+		list.Children(vObj, i)
+		keys[i] = sn.keyOf(vObj, level)
+	}
+	return keys
+}
+
+// length returns the number of children of the view.
+func (view *View[K, VT, V, VList]) length() int {
+	if view.buffer == nil {
+		return 0
+	}
+	return view.Vlist.ChildrenLength()
+}
+
+// sortOffsets orders the children offsets by key for a sorted layout. The indexes are only
+// needed to build the view, so they are left untouched.
+func (sn *FlatNode[K, VT, V, VList]) sortOffsets(newIndexes map[K]int, newOffsets []flatbuffers.UOffsetT) {
+	keys := make([]K, len(newOffsets))
+	for k, i := range newIndexes {
+		keys[i] = k
+	}
+	order := make([]int, len(newOffsets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return sn.conf.CompareKeys(keys[a], keys[b]) })
+	sorted := make([]flatbuffers.UOffsetT, len(newOffsets))
+	for i, j := range order {
+		sorted[i] = newOffsets[j]
+	}
+	copy(newOffsets, sorted)
+}
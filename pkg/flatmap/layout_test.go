@@ -0,0 +1,89 @@
+package flatmap_test
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func newSortedLayoutConfig(levels int) *bookConfig {
+	conf := newBookConfig(levels)
+	conf.CompareKeys = cmp.Compare[int]
+	conf.SortedLayout = true
+	return conf
+}
+
+func TestSortedLayout(t *testing.T) {
+	conf := newSortedLayoutConfig(2)
+	conf.HistoryDepth = 2
+	conf.Indexes = []flatmap.IndexDef[*books.Book]{
+		flatmap.NewIndex("pages", func(b *books.Book) []uint64 { return []uint64{b.PageCount()} }),
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := 100; id > 0; id -= 3 {
+		deltas = append(deltas, bookDelta(2, uint64(id), uint64(id), 0))
+	}
+	node.FeedDeltaBulk(deltas)
+	from := node.Version()
+	for id := 100; id > 0; id -= 3 {
+		expectPageCount(t, node, []int{id % 10, id}, uint64(id))
+		expectPageCount(t, node, []int{(id + 1) % 10, id + 1}, 0)
+	}
+	if keys := collectKeys(node.Range(nil, 10, 20), 0); !slices.Equal(keys, []int{10, 13, 16, 19}) {
+		t.Fatalf("Range(10, 20) = %v, want [10 13 16 19]", keys)
+	}
+
+	node.Delete([]int{1, 91})
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 5, 0), {Keys: []int{4, 94}, Delete: true}, bookDelta(2, 55, 1, 0)})
+	expectPageCount(t, node, []int{1, 91}, 0)
+	expectPageCount(t, node, []int{4, 94}, 0)
+	expectPageCount(t, node, []int{5, 55}, 1)
+	expectPageCount(t, node, []int{1, 11}, 5)
+	if inPlace, err := node.MutateInPlace([]int{1, 61}, func(b *books.Book) bool { return b.MutatePageCount(8) }, nil); !inPlace || err != nil {
+		t.Fatalf("MutateInPlace = %v, %v", inPlace, err)
+	}
+	if found, _ := node.GetBy("pages", uint64(8)); len(found) != 1 || found[0].Id() != 61 {
+		t.Fatalf("GetBy(pages, 8) found %d books, want book 61", len(found))
+	}
+	added, removed, changed, err := node.DiffVersions(from, node.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || len(removed) != 2 || len(changed) != 2 {
+		t.Fatalf("DiffVersions = %v, %v, %v", added, removed, changed)
+	}
+}
+
+func TestSortedLayoutSnapshot(t *testing.T) {
+	node := flatmap.NewFlatNode(newSortedLayoutConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 3, 3, 0), bookDelta(1, 1, 1, 0), bookDelta(1, 2, 2, 0)})
+	snapshot := node.GetSnapshot([]int{1}, true)
+	if snapshot.Keys != nil {
+		t.Fatalf("a sorted layout snapshot carries keys %v, the buffer indexes itself", snapshot.Keys)
+	}
+
+	// a map layout reads the keys from the buffer
+	snapshot.Path = []int{}
+	restored := flatmap.NewFlatNode(newBookConfig(1), 0)
+	restored.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+	restored.Update(nil)
+	for id := uint64(1); id <= 3; id++ {
+		expectPageCount(t, restored, []int{int(id)}, id)
+	}
+	expectPageCount(t, restored, []int{4}, 0)
+}
+
+func TestSortedLayoutNeedsCompareKeys(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.SortedLayout = true
+	if err := conf.Validate(); err == nil {
+		t.Fatal("Validate accepted SortedLayout without CompareKeys")
+	}
+}
@@ -0,0 +1,72 @@
+package flatmap
+
+import (
+	"reflect"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// LeafList reads the children of a leaf buffer: the vector in the first field of its root
+// table, the layout VListStart and VListAddChildren write. FlatNode reads its leaves through
+// it, so a schema does not need to declare a list table; *LeafList[VT, V] can be used as the
+// VList of a FlatConfig when it declares none.
+type LeafList[VT VTypeT, V VType[VT]] struct {
+	bytes  []byte
+	vector flatbuffers.UOffsetT // start of the vector, 0 when the table has none
+	length int
+}
+
+// Init reads the list table at position i of buf.
+func (l *LeafList[VT, V]) Init(buf []byte, i flatbuffers.UOffsetT) {
+	tab := flatbuffers.Table{Bytes: buf, Pos: i}
+	*l = LeafList[VT, V]{bytes: buf}
+	if o := flatbuffers.UOffsetT(tab.Offset(flatbuffers.VtableMetadataFields * flatbuffers.SizeVOffsetT)); o != 0 {
+		l.vector = tab.Vector(o)
+		l.length = tab.VectorLen(o)
+	}
+}
+
+// Children points obj at the child j, it returns false when j is out of range.
+func (l *LeafList[VT, V]) Children(obj V, j int) bool {
+	if j < 0 || j >= l.length {
+		return false
+	}
+	x := l.vector + flatbuffers.UOffsetT(j)*flatbuffers.SizeUOffsetT
+	obj.Init(l.bytes, x+flatbuffers.GetUOffsetT(l.bytes[x:]))
+	return true
+}
+
+// ChildrenLength returns the number of children.
+func (l *LeafList[VT, V]) ChildrenLength() int {
+	return l.length
+}
+
+// leafList returns the list of a finished leaf buffer.
+func (sn *FlatNode[K, VT, V, VList]) leafList(buf []byte) LeafList[VT, V] {
+	var list LeafList[VT, V]
+	list.Init(buf, flatbuffers.GetUOffsetT(buf))
+	return list
+}
+
+// leafKeyIndex returns the encoded key index in the second field of the root table of a leaf
+// buffer, the layout VListAddKeyIndex writes; nil when it has none.
+func leafKeyIndex(buf []byte) []byte {
+	tab := flatbuffers.Table{Bytes: buf, Pos: flatbuffers.GetUOffsetT(buf)}
+	if o := flatbuffers.UOffsetT(tab.Offset((flatbuffers.VtableMetadataFields + 1) * flatbuffers.SizeVOffsetT)); o != 0 {
+		return tab.ByteVector(tab.Pos + o)
+	}
+	return nil
+}
+
+// newVList returns an empty VList, from FlatConfig.NewVList when set. Otherwise pointer
+// types are allocated through reflection.
+func (sn *FlatNode[K, VT, V, VList]) newVList() VList {
+	if sn.conf.NewVList != nil {
+		return sn.conf.NewVList()
+	}
+	var list VList
+	if t := reflect.TypeFor[VList](); t.Kind() == reflect.Pointer {
+		list = reflect.New(t.Elem()).Interface().(VList)
+	}
+	return list
+}
@@ -0,0 +1,60 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+type leafList = flatmap.LeafList[*books.BookT, *books.Book]
+
+func TestLeafList(t *testing.T) {
+	conf := &flatmap.FlatConfig[int, *books.BookT, *books.Book, *leafList]{
+		UpdateSeconds: 3600,
+		NewV:          func() *books.Book { return &books.Book{} },
+		GetKeysFromV:  func(b *books.Book) []int { return []int{int(b.Id())} },
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 11, 5, 0), bookDelta(1, 21, 6, 0)})
+	book := &books.Book{}
+	if !node.Get([]int{21}, book) || book.PageCount() != 6 {
+		t.Fatalf("Get(21) = %v, want page count 6", book.PageCount())
+	}
+	list, ok := node.GetBatch(nil)
+	if !ok || list.ChildrenLength() != 2 || !list.Children(book, 1) || list.Children(book, 2) {
+		t.Fatalf("GetBatch = %v, %v, want 2 children", list, ok)
+	}
+
+	// a tree with a list table reads the snapshots of a LeafList tree
+	snapshot := node.GetSnapshot([]int{11}, true)
+	snapshot.Path = []int{}
+	old := flatmap.NewFlatNode(newBookConfig(1), 0)
+	old.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+	old.Update(nil)
+	oldList, ok := old.GetBatch(nil)
+	if !ok || oldList.ChildrenLength() != 2 {
+		t.Fatalf("GetBatch of the restored tree = %v, %v, want 2 children", oldList, ok)
+	}
+	expectPageCount(t, old, []int{11}, 5)
+}
+
+func TestGetBatchIsCached(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 5, 0)})
+	first, _ := node.GetBatch([]int{1})
+	second, _ := node.GetBatch([]int{1})
+	if first != second {
+		t.Fatal("GetBatch built a new list for the same view")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { node.GetBatch([]int{1}) }); allocs != 0 {
+		t.Fatalf("GetBatch allocates %v times, want 0", allocs)
+	}
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 21, 6, 0)})
+	if third, _ := node.GetBatch([]int{1}); third == first || third.ChildrenLength() != 2 {
+		t.Fatal("GetBatch kept the list of a replaced view")
+	}
+}
@@ -0,0 +1,549 @@
+package flatmap
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Loader bulk loads CSV or JSON lines rows into shard buffers, without going through
+// DeltaItems: each row is written straight into the builder of its shard. Rows are grouped
+// by their key path and Snapshots returns one ShardSnapshot per shard, ready for
+// InitializeWithGroupedShardBuffers. Fields can be scalars, enums (by name or number),
+// strings and vectors of those.
+type Loader[K comparable] struct {
+	Schema        *SchemaObject                    // the table of V
+	KeyFields     []string                         // the field holding the key of each tree level
+	Keys          func(row LoaderRow) ([]K, error) // optional, derives the keys instead of KeyFields
+	Columns       map[string]string                // optional, CSV column or JSON property -> field, "" skips it
+	ListSeparator string                           // optional, separates the elements of CSV vectors, ";" by default
+	CompareKeys   func(a, b K) int                 // optional, writes the shards sorted for a SortedLayout map
+	ConvertKey    func(value any) (K, error)       // optional, converts a key field value to K
+
+	fields  []*SchemaField
+	keyIdx  []int
+	numSlot int
+	root    loaderNode[K]
+	shards  []*shardBuilder[K]
+	rows    int
+
+	// reused by every row
+	values  []any
+	offsets []flatbuffers.UOffsetT
+	keys    []K
+}
+
+// LoaderRow gives Loader.Keys the parsed values of a row.
+type LoaderRow struct {
+	fields []*SchemaField
+	values []any
+}
+
+// Value returns the parsed value of a field, nil when the row does not set it. Scalars have
+// the Go type of their base type and vectors are []any.
+func (r LoaderRow) Value(name string) any {
+	for i, f := range r.fields {
+		if f.Name == name {
+			return r.values[i]
+		}
+	}
+	return nil
+}
+
+type loaderNode[K comparable] struct {
+	children map[K]*loaderNode[K]
+	shard    *shardBuilder[K]
+}
+
+type shardBuilder[K comparable] struct {
+	path      []K
+	builder   *flatbuffers.Builder
+	keys      []K
+	offsets   []flatbuffers.UOffsetT
+	positions map[K]int
+}
+
+// Rows returns the number of rows loaded so far.
+func (l *Loader[K]) Rows() int {
+	return l.rows
+}
+
+// LoadCSV loads the rows of a CSV stream whose first record names the columns. Columns
+// without a matching field are skipped.
+func (l *Loader[K]) LoadCSV(r io.Reader) error {
+	if err := l.prepare(); err != nil {
+		return err
+	}
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	columns := make([]int, len(header)) // column -> field index, -1 when skipped
+	for i, name := range header {
+		columns[i] = l.fieldIndex(name)
+		if columns[i] >= 0 {
+			if err := checkLoaderField(l.fields[columns[i]]); err != nil {
+				return err
+			}
+		}
+	}
+	separator := l.ListSeparator
+	if separator == "" {
+		separator = ";"
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		clear(l.values)
+		for i, raw := range record {
+			if i >= len(columns) || columns[i] < 0 || raw == "" {
+				continue
+			}
+			f := l.fields[columns[i]]
+			if l.values[columns[i]], err = parseCSVValue(f, raw, separator); err != nil {
+				return fmt.Errorf("line %d, column %s: %w", line, header[i], err)
+			}
+		}
+		if err := l.addRow(); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// LoadJSONL loads a stream of JSON objects, usually one per line. Properties without a
+// matching field are skipped.
+func (l *Loader[K]) LoadJSONL(r io.Reader) error {
+	if err := l.prepare(); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	dec.UseNumber()
+	for row := 1; ; row++ {
+		var object map[string]any
+		if err := dec.Decode(&object); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+		clear(l.values)
+		for name, value := range object {
+			i := l.fieldIndex(name)
+			if i < 0 || value == nil {
+				continue
+			}
+			f := l.fields[i]
+			if err := checkLoaderField(f); err != nil {
+				return fmt.Errorf("row %d: %w", row, err)
+			}
+			var err error
+			if l.values[i], err = parseJSONValue(f, value); err != nil {
+				return fmt.Errorf("row %d, field %s: %w", row, name, err)
+			}
+		}
+		if err := l.addRow(); err != nil {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+	}
+}
+
+// Snapshots finishes the shard buffers and returns their snapshots. The loader must not be
+// used afterwards.
+func (l *Loader[K]) Snapshots() []*ShardSnapshot[K] {
+	snapshots := make([]*ShardSnapshot[K], 0, len(l.shards))
+	for _, shard := range l.shards {
+		snapshots = append(snapshots, shard.finish(l.CompareKeys))
+	}
+	l.shards, l.root = nil, loaderNode[K]{}
+	return snapshots
+}
+
+func (l *Loader[K]) prepare() error {
+	if l.fields != nil {
+		return nil
+	}
+	if l.Schema == nil || l.Schema.IsStruct {
+		return fmt.Errorf("the loader needs the schema of a table")
+	}
+	if len(l.KeyFields) == 0 && l.Keys == nil {
+		return fmt.Errorf("no key fields")
+	}
+	fields := l.Schema.Fields
+	for _, name := range l.KeyFields {
+		i := slices.IndexFunc(fields, func(f *SchemaField) bool { return f.Name == name })
+		if i < 0 {
+			return fmt.Errorf("%s has no key field %q", l.Schema.Name, name)
+		}
+		if base := fields[i].Type.Base; !base.IsScalar() && base != BaseTypeString {
+			return fmt.Errorf("key field %s must be a scalar or a string", name)
+		}
+		l.keyIdx = append(l.keyIdx, i)
+	}
+	for _, f := range fields {
+		l.numSlot = max(l.numSlot, f.ID+1)
+	}
+	l.fields = fields
+	l.values = make([]any, len(fields))
+	l.offsets = make([]flatbuffers.UOffsetT, len(fields))
+	return nil
+}
+
+// fieldIndex returns the index of the field a column maps to, -1 if none.
+func (l *Loader[K]) fieldIndex(column string) int {
+	name := column
+	if mapped, ok := l.Columns[column]; ok {
+		name = mapped
+	}
+	if name == "" {
+		return -1
+	}
+	return slices.IndexFunc(l.fields, func(f *SchemaField) bool { return f.Name == name && !f.Deprecated })
+}
+
+func checkLoaderField(f *SchemaField) error {
+	switch {
+	case f.Type.Base.IsScalar() && f.Type.Base != BaseTypeUType, f.Type.Base == BaseTypeString:
+		return nil
+	case f.Type.Base == BaseTypeVector && (f.Type.Element.IsScalar() || f.Type.Element == BaseTypeString):
+		return nil
+	}
+	return fmt.Errorf("field %s is not supported by the loader", f.Name)
+}
+
+func (l *Loader[K]) addRow() error {
+	keys, err := l.rowKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys")
+	}
+	shard := l.shardFor(keys[:len(keys)-1])
+	offset := l.writeTable(shard.builder)
+	key := keys[len(keys)-1]
+	if i, ok := shard.positions[key]; ok { // the latest row wins
+		shard.offsets[i] = offset
+	} else {
+		shard.positions[key] = len(shard.keys)
+		shard.keys = append(shard.keys, key)
+		shard.offsets = append(shard.offsets, offset)
+	}
+	l.rows++
+	return nil
+}
+
+func (l *Loader[K]) rowKeys() ([]K, error) {
+	if l.Keys != nil {
+		return l.Keys(LoaderRow{fields: l.fields, values: l.values})
+	}
+	l.keys = l.keys[:0]
+	for _, i := range l.keyIdx {
+		value := l.values[i]
+		if value == nil {
+			return nil, fmt.Errorf("missing key field %s", l.fields[i].Name)
+		}
+		key, err := l.convertKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("key field %s: %w", l.fields[i].Name, err)
+		}
+		l.keys = append(l.keys, key)
+	}
+	return l.keys, nil
+}
+
+// convertKey converts a key field value to K. A Key takes any value KeyOf does, other types
+// are converted between numbers or between strings.
+func (l *Loader[K]) convertKey(value any) (K, error) {
+	if l.ConvertKey != nil {
+		return l.ConvertKey(value)
+	}
+	if key, ok := value.(K); ok {
+		return key, nil
+	}
+	var key K
+	if _, ok := any(key).(Key); ok {
+		composite, err := KeyOf(value)
+		return any(composite).(K), err
+	}
+	rv, kt := reflect.ValueOf(value), reflect.TypeFor[K]()
+	if (rv.Kind() == reflect.String) != (kt.Kind() == reflect.String) || !rv.CanConvert(kt) {
+		return key, fmt.Errorf("cannot convert %T to %s, set Loader.ConvertKey", value, kt)
+	}
+	return rv.Convert(kt).Interface().(K), nil
+}
+
+func (l *Loader[K]) shardFor(path []K) *shardBuilder[K] {
+	node := &l.root
+	for _, key := range path {
+		if node.children == nil {
+			node.children = make(map[K]*loaderNode[K])
+		}
+		child, ok := node.children[key]
+		if !ok {
+			child = &loaderNode[K]{}
+			node.children[key] = child
+		}
+		node = child
+	}
+	if node.shard == nil {
+		node.shard = &shardBuilder[K]{
+			path:      slices.Clone(path),
+			builder:   flatbuffers.NewBuilder(1024),
+			positions: make(map[K]int),
+		}
+		l.shards = append(l.shards, node.shard)
+	}
+	return node.shard
+}
+
+// writeTable writes the parsed values of the row as a table.
+func (l *Loader[K]) writeTable(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	for i, f := range l.fields { // strings and vectors go before the table
+		value := l.values[i]
+		if value == nil {
+			continue
+		}
+		switch f.Type.Base {
+		case BaseTypeString:
+			l.offsets[i] = b.CreateString(value.(string))
+		case BaseTypeVector:
+			l.offsets[i] = writeVector(b, f.Type.Element, value.([]any))
+		}
+	}
+	b.StartObject(l.numSlot)
+	for i, f := range l.fields {
+		value := l.values[i]
+		if value == nil {
+			continue
+		}
+		switch f.Type.Base {
+		case BaseTypeString, BaseTypeVector:
+			b.PrependUOffsetTSlot(f.ID, l.offsets[i], 0)
+		default:
+			prependScalarSlot(b, f, value)
+		}
+	}
+	return b.EndObject()
+}
+
+func writeVector(b *flatbuffers.Builder, element BaseType, values []any) flatbuffers.UOffsetT {
+	if element == BaseTypeString {
+		offsets := make([]flatbuffers.UOffsetT, len(values))
+		for i, value := range values {
+			offsets[i] = b.CreateString(value.(string))
+		}
+		b.StartVector(flatbuffers.SizeUOffsetT, len(values), flatbuffers.SizeUOffsetT)
+		for i := len(offsets) - 1; i >= 0; i-- {
+			b.PrependUOffsetT(offsets[i])
+		}
+		return b.EndVector(len(values))
+	}
+	b.StartVector(element.Size(), len(values), element.Size())
+	for i := len(values) - 1; i >= 0; i-- {
+		prependScalar(b, values[i])
+	}
+	return b.EndVector(len(values))
+}
+
+func prependScalar(b *flatbuffers.Builder, value any) {
+	switch v := value.(type) {
+	case bool:
+		b.PrependBool(v)
+	case int8:
+		b.PrependInt8(v)
+	case uint8:
+		b.PrependUint8(v)
+	case int16:
+		b.PrependInt16(v)
+	case uint16:
+		b.PrependUint16(v)
+	case int32:
+		b.PrependInt32(v)
+	case uint32:
+		b.PrependUint32(v)
+	case int64:
+		b.PrependInt64(v)
+	case uint64:
+		b.PrependUint64(v)
+	case float32:
+		b.PrependFloat32(v)
+	case float64:
+		b.PrependFloat64(v)
+	}
+}
+
+// prependScalarSlot writes a scalar field, skipped by the builder when it holds the default.
+func prependScalarSlot(b *flatbuffers.Builder, f *SchemaField, value any) {
+	def := f.DefaultInteger
+	switch v := value.(type) {
+	case bool:
+		b.PrependBoolSlot(f.ID, v, def != 0)
+	case int8:
+		b.PrependInt8Slot(f.ID, v, int8(def))
+	case uint8:
+		b.PrependUint8Slot(f.ID, v, uint8(def))
+	case int16:
+		b.PrependInt16Slot(f.ID, v, int16(def))
+	case uint16:
+		b.PrependUint16Slot(f.ID, v, uint16(def))
+	case int32:
+		b.PrependInt32Slot(f.ID, v, int32(def))
+	case uint32:
+		b.PrependUint32Slot(f.ID, v, uint32(def))
+	case int64:
+		b.PrependInt64Slot(f.ID, v, def)
+	case uint64:
+		b.PrependUint64Slot(f.ID, v, uint64(def))
+	case float32:
+		b.PrependFloat32Slot(f.ID, v, float32(f.DefaultReal))
+	case float64:
+		b.PrependFloat64Slot(f.ID, v, f.DefaultReal)
+	}
+}
+
+// finish writes the children vector in the layout FlatNode builds its leaves with.
+func (s *shardBuilder[K]) finish(compare func(a, b K) int) *ShardSnapshot[K] {
+	keys, offsets := s.keys, s.offsets
+	if compare != nil {
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortFunc(order, func(a, b int) int { return compare(s.keys[a], s.keys[b]) })
+		offsets = make([]flatbuffers.UOffsetT, len(order))
+		for i, j := range order {
+			offsets[i] = s.offsets[j]
+		}
+		keys = nil // a sorted layout indexes itself
+	}
+	b := s.builder
+	b.StartVector(flatbuffers.SizeUOffsetT, len(offsets), flatbuffers.SizeUOffsetT)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	children := b.EndVector(len(offsets))
+	b.StartObject(1)
+	b.PrependUOffsetTSlot(0, children, 0)
+	b.Finish(b.EndObject())
+	return &ShardSnapshot[K]{Path: s.path, Keys: keys, Buffer: b.FinishedBytes()}
+}
+
+func parseCSVValue(f *SchemaField, raw string, separator string) (any, error) {
+	if f.Type.Base != BaseTypeVector {
+		return parseScalar(f.Type.Base, f.Type.Enum, raw)
+	}
+	parts := strings.Split(raw, separator)
+	values := make([]any, len(parts))
+	for i, part := range parts {
+		value, err := parseScalar(f.Type.Element, f.Type.Enum, part)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func parseJSONValue(f *SchemaField, value any) (any, error) {
+	if f.Type.Base != BaseTypeVector {
+		return parseJSONScalar(f.Type.Base, f.Type.Enum, value)
+	}
+	elements, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %T", value)
+	}
+	values := make([]any, len(elements))
+	for i, element := range elements {
+		parsed, err := parseJSONScalar(f.Type.Element, f.Type.Enum, element)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = parsed
+	}
+	return values, nil
+}
+
+func parseJSONScalar(base BaseType, enum *SchemaEnum, value any) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return parseScalar(base, enum, v.String())
+	case string:
+		return parseScalar(base, enum, v)
+	case bool:
+		if base == BaseTypeBool {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %T", value)
+}
+
+// parseScalar parses raw into the Go type of base, enums accepting their value names.
+// Integers are decimal, leading zeros included.
+func parseScalar(base BaseType, enum *SchemaEnum, raw string) (any, error) {
+	raw = strings.TrimSpace(raw)
+	if enum != nil {
+		for _, ev := range enum.Values {
+			if ev.Name == raw {
+				raw = strconv.FormatInt(ev.Value, 10)
+				break
+			}
+		}
+	}
+	switch base {
+	case BaseTypeString:
+		return raw, nil
+	case BaseTypeBool:
+		return strconv.ParseBool(raw)
+	case BaseTypeFloat:
+		v, err := strconv.ParseFloat(raw, 32)
+		return float32(v), err
+	case BaseTypeDouble:
+		return strconv.ParseFloat(raw, 64)
+	case BaseTypeByte, BaseTypeShort, BaseTypeInt, BaseTypeLong:
+		v, err := strconv.ParseInt(raw, 10, base.Size()*8)
+		if err != nil {
+			return nil, err
+		}
+		switch base {
+		case BaseTypeByte:
+			return int8(v), nil
+		case BaseTypeShort:
+			return int16(v), nil
+		case BaseTypeInt:
+			return int32(v), nil
+		}
+		return v, nil
+	case BaseTypeUByte, BaseTypeUShort, BaseTypeUInt, BaseTypeULong:
+		v, err := strconv.ParseUint(raw, 10, base.Size()*8)
+		if err != nil {
+			return nil, err
+		}
+		switch base {
+		case BaseTypeUByte:
+			return uint8(v), nil
+		case BaseTypeUShort:
+			return uint16(v), nil
+		case BaseTypeUInt:
+			return uint32(v), nil
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported type %d", base)
+}
@@ -0,0 +1,119 @@
+package flatmap_test
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func loadBooksSchema(t *testing.T) *flatmap.SchemaObject {
+	t.Helper()
+	schema, err := flatmap.LoadSchema("../../example/books.fbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema.Object("Book")
+}
+
+func restoreBooks(t *testing.T, conf *bookConfig, snapshots []*flatmap.ShardSnapshot[int]) *bookNode {
+	t.Helper()
+	node := flatmap.NewFlatNode(conf, 0)
+	node.InitializeWithGroupedShardBuffers(snapshots)
+	node.Update(nil)
+	return node
+}
+
+func TestLoader(t *testing.T) {
+	loader := &flatmap.Loader[int]{
+		Schema:  loadBooksSchema(t),
+		Columns: map[string]string{"pages": "page_count", "junk": ""},
+		Keys: func(row flatmap.LoaderRow) ([]int, error) {
+			return []int{int(row.Value("id").(uint64))}, nil
+		},
+	}
+	csv := "id,title,pages,rate,list_field,scalar_list_field,junk,other\n" +
+		"11,a,5,1.5,x;y,1;2,q,z\n" +
+		"21,b,6,,,,,\n" +
+		"11,a2,9,,,,,\n" // the last row of a key wins
+	if err := loader.LoadCSV(strings.NewReader(csv)); err != nil {
+		t.Fatal(err)
+	}
+	jsonl := `{"id": 33, "title": "j", "page_count": 3, "list_field": ["k"], "scalar_list_field": [4]}
+{"id": "43", "page_count": "8"}`
+	if err := loader.LoadJSONL(strings.NewReader(jsonl)); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.LoadCSV(strings.NewReader("id,page_count\n1,abc\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("LoadCSV of a bad number = %v, want an error on line 2", err)
+	}
+	if loader.Rows() != 5 {
+		t.Fatalf("Rows() = %d, want 5", loader.Rows())
+	}
+
+	node := restoreBooks(t, newBookConfig(1), loader.Snapshots())
+	book := &books.Book{}
+	if !node.Get([]int{11}, book) || book.PageCount() != 9 || string(book.Title()) != "a2" {
+		t.Fatalf("book 11 = %+v", book.UnPack())
+	}
+	if !node.Get([]int{33}, book) || book.PageCount() != 3 || string(book.ListField(0)) != "k" || book.ScalarListField(0) != 4 {
+		t.Fatalf("book 33 = %+v", book.UnPack())
+	}
+	expectPageCount(t, node, []int{21}, 6)
+	expectPageCount(t, node, []int{43}, 8)
+}
+
+func TestLoaderShards(t *testing.T) {
+	loader := &flatmap.Loader[int]{
+		Schema: loadBooksSchema(t),
+		Keys: func(row flatmap.LoaderRow) ([]int, error) {
+			id := int(row.Value("id").(uint64))
+			return []int{id % 10, id}, nil
+		},
+	}
+	if err := loader.LoadCSV(strings.NewReader("id,page_count\n11,1\n21,2\n12,3\n33,4\n")); err != nil {
+		t.Fatal(err)
+	}
+	var paths [][]int
+	for _, snapshot := range loader.Snapshots() {
+		paths = append(paths, snapshot.Path)
+	}
+	slices.SortFunc(paths, slices.Compare)
+	if len(paths) != 3 || !slices.Equal(paths[0], []int{1}) || !slices.Equal(paths[2], []int{3}) {
+		t.Fatalf("snapshot paths = %v, want [1] [2] [3]", paths)
+	}
+}
+
+func TestLoaderSortedLayout(t *testing.T) {
+	loader := &flatmap.Loader[int]{Schema: loadBooksSchema(t), KeyFields: []string{"id"}, CompareKeys: cmp.Compare[int]}
+	if err := loader.LoadCSV(strings.NewReader("id,title,page_count,rate\n5,a,1,2.5\n2,b,2,0\n9,c,3,0\n")); err != nil {
+		t.Fatal(err)
+	}
+	conf := newBookConfig(1)
+	conf.SortedLayout = true
+	conf.CompareKeys = cmp.Compare[int]
+	node := restoreBooks(t, conf, loader.Snapshots())
+	if keys := collectKeys(node.Ascend(nil), 0); !slices.Equal(keys, []int{2, 5, 9}) {
+		t.Fatalf("Ascend = %v, want [2 5 9]", keys)
+	}
+	book := &books.Book{}
+	if !node.Get([]int{5}, book) || book.Rate() != 2.5 {
+		t.Fatalf("book 5 = %+v", book.UnPack())
+	}
+}
+
+func TestLoaderDecimalIntegers(t *testing.T) {
+	loader := &flatmap.Loader[int]{Schema: loadBooksSchema(t), KeyFields: []string{"id"}}
+	if err := loader.LoadCSV(strings.NewReader("id,page_count\n010,08\n7,0x10\n")); err == nil {
+		t.Fatal("LoadCSV accepted a hexadecimal page count")
+	}
+	loader = &flatmap.Loader[int]{Schema: loadBooksSchema(t), KeyFields: []string{"id"}}
+	if err := loader.LoadCSV(strings.NewReader("id,page_count\n010,08\n")); err != nil {
+		t.Fatal(err)
+	}
+	node := restoreBooks(t, newBookConfig(1), loader.Snapshots())
+	expectPageCount(t, node, []int{10}, 8)
+}
@@ -0,0 +1,61 @@
+package flatmap
+
+// Map is the interface of a FlatNode tree without its VT and VList type parameters, for the
+// layers that only store and read entries, and for fakes in tests; see MemoryMap. Set and
+// Delete are queued until Update, or the periodic update of a tree. A FlatNode returns its
+// VList from GetBatch, so it is used as a Map through FlatNode.AsMap.
+type Map[K comparable, V any] interface {
+	Get(keys []K, v V) bool
+	GetBatch(keys []K) (List[V], bool)
+	Set(delta DeltaItem[K]) error
+	Delete(keys []K)
+	Update(bulkDelta []DeltaItem[K])
+	GetSnapshot(keys []K, deepCopy bool) *ShardSnapshot[K]
+	Iterate(prefix []K, fn func(v V) bool)
+	Close() error
+}
+
+// List is the list of children of a shard returned by Map.GetBatch. The VList of a FlatNode
+// implements it, a type assertion gives it back.
+type List[V any] interface {
+	Children(v V, j int) bool
+	ChildrenLength() int
+}
+
+func _[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]]() {
+	var _ Map[K, V] = nodeMap[K, VT, V, VList]{}
+	var _ Map[K, V] = (*MemoryMap[K, VT, V, VList])(nil)
+}
+
+// AsMap returns the tree as a Map, whose GetBatch returns the VList of the shard as a List.
+func (sn *FlatNode[K, VT, V, VList]) AsMap() Map[K, V] {
+	return nodeMap[K, VT, V, VList]{sn}
+}
+
+// nodeMap adapts the GetBatch of a FlatNode to Map.
+type nodeMap[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
+	*FlatNode[K, VT, V, VList]
+}
+
+func (m nodeMap[K, VT, V, VList]) GetBatch(keys []K) (List[V], bool) {
+	list, ok := m.FlatNode.GetBatch(keys)
+	if !ok {
+		return nil, false // not a nil VList in a List
+	}
+	return list, true
+}
+
+// Iterate calls fn for the entries under prefix until it returns false, from one tree
+// version. v is reused between calls.
+func (sn *FlatNode[K, VT, V, VList]) Iterate(prefix []K, fn func(v V) bool) {
+	txn := sn.BeginRead()
+	defer txn.Close()
+	txn.Iterate(prefix, fn)
+}
+
+// Close stops the periodic updates of every node of the tree. The map stays readable and
+// Update still applies the queued deltas.
+func (sn *FlatNode[K, VT, V, VList]) Close() error {
+	sn.conf.closeOnce.Do(func() { close(sn.conf.done) })
+	return nil
+}
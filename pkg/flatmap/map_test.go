@@ -0,0 +1,179 @@
+package flatmap_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+type bookMap = flatmap.Map[int, *books.Book]
+
+// randomDelta writes, patches or deletes book id, versioned two times out of three.
+func randomDelta(rng *rand.Rand, id uint64) flatmap.DeltaItem[int] {
+	delta := flatmap.DeltaItem[int]{Keys: []int{int(id)}}
+	if rng.IntN(3) != 0 {
+		delta.Version = 1 + rng.Uint64N(12)
+	}
+	book := &books.BookT{Id: id}
+	switch rng.IntN(5) {
+	case 0:
+		delta.Delete = true
+		return delta
+	case 1:
+		delta.Patch = true
+		book.PageCount = 1 + rng.Uint64N(9)
+	case 2:
+		delta.Patch = true
+		book.Title = "patched"
+	default:
+		book.Title = "title"
+		book.PageCount = 1 + rng.Uint64N(9)
+	}
+	if rng.IntN(8) == 0 {
+		book.Title = "gone"
+	}
+	delta.Data = packBook(book)
+	return delta
+}
+
+// describeMap lists the books of the map, in shard order, and its stale delta count.
+func describeMap(m bookMap, stale uint64) string {
+	out := fmt.Sprintf("stale %d:", stale)
+	book := &books.Book{}
+	for id := range 5 {
+		if m.Get([]int{id}, book) {
+			out += fmt.Sprintf(" %d=%d/%s", id, book.PageCount(), book.Title())
+		}
+	}
+	if list, ok := m.GetBatch(nil); ok {
+		out += " order:"
+		for i := range list.ChildrenLength() {
+			list.Children(book, i)
+			out += fmt.Sprintf(" %d", book.Id())
+		}
+	}
+	if snapshot := m.GetSnapshot([]int{0}, true); snapshot != nil {
+		out += fmt.Sprintf(" snapshot %v@%d", snapshot.Keys, snapshot.Version)
+	}
+	return out
+}
+
+func TestMapParity(t *testing.T) {
+	schema, err := flatmap.LoadSchema("../../example/books.fbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := map[string]func(conf *bookConfig){
+		"versions":        func(conf *bookConfig) {},
+		"version from v":  func(conf *bookConfig) { conf.GetVersionFromV = func(b *books.Book) uint64 { return b.PageCount() } },
+		"tombstoneWindow": func(conf *bookConfig) { conf.TombstoneWindow = 3 },
+	}
+	for name, configure := range configs {
+		t.Run(name, func(t *testing.T) {
+			newConfig := func() *bookConfig {
+				conf := newBookConfig(1)
+				conf.Schema = schema.Object("Book")
+				conf.CheckVForDelete = func(b *books.Book) bool { return string(b.Title()) == "gone" }
+				configure(conf)
+				return conf
+			}
+			for seed := range uint64(50) {
+				rng := rand.New(rand.NewPCG(seed, 0))
+				node := flatmap.NewFlatNode(newConfig(), 0)
+				memory := flatmap.NewMemoryMap(newConfig())
+				for step := range 40 {
+					// one to three deltas for one of five books in a cycle, the order of
+					// several books written in one cycle is not defined
+					id := rng.Uint64N(5)
+					cycle := []flatmap.DeltaItem[int]{randomDelta(rng, id)}
+					for rng.IntN(3) == 0 && len(cycle) < 3 {
+						cycle = append(cycle, randomDelta(rng, id))
+					}
+					node.FeedDeltaBulk(cycle)
+					for _, delta := range cycle {
+						if err := memory.Set(delta); err != nil {
+							t.Fatal(err)
+						}
+					}
+					memory.Update(nil)
+					got := describeMap(memory, memory.StaleDeltaCount())
+					want := describeMap(node.AsMap(), node.StaleDeltaCount())
+					if got != want {
+						t.Fatalf("seed %d, step %d, after %+v:\nMemoryMap %s\nFlatNode  %s", seed, step, cycle, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMapGetBatch(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 5, 0)})
+	list, ok := node.GetBatch([]int{1})
+	if !ok || list.ChildrenLength() != 1 {
+		t.Fatalf("GetBatch = %v, %v, want one book", list, ok)
+	}
+	for name, m := range map[string]bookMap{"FlatNode": node.AsMap(), "MemoryMap": flatmap.NewMemoryMap(newBookConfig(2))} {
+		if list, ok := m.GetBatch([]int{7}); ok || list != nil {
+			t.Errorf("%s: GetBatch of a missing shard = %v, %v, want nil, false", name, list, ok)
+		}
+	}
+	if batch, _ := node.AsMap().GetBatch([]int{1}); batch.(*books.BookList) != list {
+		t.Fatal("Map.GetBatch does not hold the VList of the tree")
+	}
+}
+
+func TestMapQueuesUntilUpdate(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	for name, m := range map[string]bookMap{"FlatNode": node.AsMap(), "MemoryMap": flatmap.NewMemoryMap(newBookConfig(1))} {
+		m.Update([]flatmap.DeltaItem[int]{bookDelta(1, 1, 5, 0), bookDelta(1, 2, 5, 0)})
+		if err := m.Set(bookDelta(1, 3, 5, 0)); err != nil {
+			t.Fatal(err)
+		}
+		book := &books.Book{}
+		if m.Get([]int{3}, book) {
+			t.Errorf("%s: Set is visible before Update", name)
+		}
+		m.Delete([]int{1})
+		m.Delete([]int{3}) // not there at the last update
+		if !m.Get([]int{1}, book) {
+			t.Errorf("%s: Delete is visible before Update", name)
+		}
+		m.Set(bookDelta(1, 2, 6, 0))
+		m.Delete([]int{2}) // the queued write still applies
+		m.Update(nil)
+		if m.Get([]int{1}, book) || !m.Get([]int{3}, book) {
+			t.Errorf("%s: Update did not apply the queued Set and Delete", name)
+		}
+		if !m.Get([]int{2}, book) || book.PageCount() != 6 {
+			t.Errorf("%s: a write queued with a Delete of its key was dropped", name)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	conf := newBookConfig(2)
+	conf.UpdateSeconds = 1
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 1, 0), bookDelta(2, 12, 1, 0), bookDelta(2, 13, 1, 0)})
+	if runtime.NumGoroutine() < before+3 {
+		t.Fatalf("%d goroutines after creating three leaves, %d before", runtime.NumGoroutine(), before)
+	}
+	node.Close()
+	node.Close()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() > before {
+		t.Fatalf("%d goroutines after Close, %d before", runtime.NumGoroutine(), before)
+	}
+	expectPageCount(t, node, []int{2, 12}, 1)
+}
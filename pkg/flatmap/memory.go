@@ -0,0 +1,413 @@
+package flatmap
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// MemoryMap is a Map backed by Go maps, a reference implementation for tests. It applies
+// deltas the way a FlatNode does, versions, tombstones, patches and CheckVForDelete included:
+// Set and Delete are queued and applied by Update, the reads see the state of the last one.
+// As on a FlatNode, Delete only removes an entry that was there at the last Update, a write
+// queued in the same cycle still applies. The entries of a shard are kept in write order, a
+// rewritten key moving to the end as in a shard buffer, or sorted with FlatConfig.SortedLayout.
+type MemoryMap[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
+	conf    *FlatConfig[K, VT, V, VList]
+	merge   func(dst VT, patch V) // see FlatConfig.resolveMergePatch
+	mu      sync.RWMutex
+	root    memoryNode[K]
+	stale   uint64
+	pending []DeltaItem[K] // queued by Set until Update
+	deletes [][]K          // queued by Delete until Update
+}
+
+type memoryNode[K comparable] struct {
+	children   map[K]*memoryNode[K]
+	entries    map[K]memoryEntry // set on leaves
+	order      []K
+	maxVersion uint64
+	tombstones map[K]uint64 // versions of the keys removed by versioned deletes
+}
+
+type memoryEntry struct {
+	data    []byte
+	version uint64
+}
+
+// NewMemoryMap returns an empty MemoryMap, conf is used as by NewFlatNode.
+func NewMemoryMap[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]](
+	conf *FlatConfig[K, VT, V, VList],
+) *MemoryMap[K, VT, V, VList] {
+	return &MemoryMap[K, VT, V, VList]{conf: conf, merge: conf.resolveMergePatch()}
+}
+
+func (m *MemoryMap[K, VT, V, VList]) Get(keys []K, v V) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	leaf := m.leaf(keys)
+	if leaf == nil {
+		return false
+	}
+	entry, ok := leaf.entries[keys[len(keys)-1]]
+	if !ok {
+		return false
+	}
+	v.Init(entry.data, flatbuffers.GetUOffsetT(entry.data))
+	return true
+}
+
+// GetBatch returns the entries of the shard at keys, in a buffer laid out as a FlatNode leaf.
+func (m *MemoryMap[K, VT, V, VList]) GetBatch(keys []K) (List[V], bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, _ := m.shard(keys)
+	if node == nil { // a shard emptied by deletes is still found, as in a FlatNode
+		return nil, false
+	}
+	list := &LeafList[VT, V]{}
+	buf := m.shardBuffer(node)
+	list.Init(buf, flatbuffers.GetUOffsetT(buf))
+	return list, true
+}
+
+// Set queues delta until the next Update.
+func (m *MemoryMap[K, VT, V, VList]) Set(delta DeltaItem[K]) error {
+	if len(delta.Keys) == 0 {
+		return fmt.Errorf("no keys provided")
+	}
+	if delta.Patch && m.merge == nil {
+		return errNoMergePatch
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, delta)
+	return nil
+}
+
+// Delete queues the removal of the entry at keys until the next Update, when there is one.
+func (m *MemoryMap[K, VT, V, VList]) Delete(keys []K) {
+	if len(keys) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if leaf := m.leaf(keys); leaf != nil {
+		if _, ok := leaf.entries[keys[len(keys)-1]]; ok {
+			m.deletes = append(m.deletes, keys)
+		}
+	}
+}
+
+// Update applies the queued deltas, then bulkDelta, shard by shard as a FlatNode update does.
+func (m *MemoryMap[K, VT, V, VList]) Update(bulkDelta []DeltaItem[K]) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var leaves []*memoryNode[K]
+	cycles := make(map[*memoryNode[K]]*memoryCycle[K])
+	cycleOf := func(leaf *memoryNode[K]) *memoryCycle[K] {
+		cycle, ok := cycles[leaf]
+		if !ok {
+			cycle = &memoryCycle[K]{deleted: make(map[K]struct{})}
+			cycles[leaf] = cycle
+			leaves = append(leaves, leaf)
+		}
+		return cycle
+	}
+	for _, keys := range m.deletes {
+		cycleOf(m.leaf(keys)).deleted[keys[len(keys)-1]] = struct{}{}
+	}
+	for _, delta := range slices.Concat(m.pending, bulkDelta) {
+		if len(delta.Keys) == 0 || delta.Patch && m.merge == nil {
+			continue // dropped as by a FlatNode update
+		}
+		cycle := cycleOf(m.makeLeaf(delta.Keys))
+		cycle.deltas = append(cycle.deltas, delta)
+	}
+	m.pending, m.deletes = nil, nil
+	for _, leaf := range leaves {
+		m.apply(leaf, cycles[leaf])
+	}
+}
+
+func (m *MemoryMap[K, VT, V, VList]) GetSnapshot(keys []K, deepCopy bool) *ShardSnapshot[K] {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, depth := m.shard(keys)
+	if node == nil || len(node.order) == 0 {
+		return nil
+	}
+	snapshot := &ShardSnapshot[K]{Path: slices.Clone(keys[:depth]), Buffer: m.shardBuffer(node)}
+	if !m.conf.SortedLayout {
+		snapshot.Keys = slices.Clone(node.order)
+	}
+	snapshot.Version = node.maxVersion
+	return snapshot
+}
+
+// Iterate calls fn for the entries under prefix until it returns false. fn may write to the
+// map, the entries are collected first.
+func (m *MemoryMap[K, VT, V, VList]) Iterate(prefix []K, fn func(v V) bool) {
+	m.mu.RLock()
+	var datas [][]byte
+	m.collect(&m.root, prefix, 0, &datas)
+	m.mu.RUnlock()
+	v := m.conf.NewV()
+	for _, data := range datas {
+		v.Init(data, flatbuffers.GetUOffsetT(data))
+		if !fn(v) {
+			return
+		}
+	}
+}
+
+// Close does nothing, a MemoryMap has no periodic updates.
+func (m *MemoryMap[K, VT, V, VList]) Close() error {
+	return nil
+}
+
+// StaleDeltaCount returns the number of versioned deltas dropped because the entry already
+// held the same or a newer version.
+func (m *MemoryMap[K, VT, V, VList]) StaleDeltaCount() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stale
+}
+
+// memoryCycle holds the deltas and deletes of a leaf applied by one Update.
+type memoryCycle[K comparable] struct {
+	deltas  []DeltaItem[K]
+	deleted map[K]struct{}
+}
+
+// apply writes the deltas of a cycle to a leaf as collectPendingKeys, processExistingChildren
+// and processPendingDeltas would.
+func (m *MemoryMap[K, VT, V, VList]) apply(leaf *memoryNode[K], cycle *memoryCycle[K]) {
+	pending := make(map[K]int) // key -> the delta applied, patches folded in
+	versions := make(map[K]uint64)
+	var keys []K
+	for i := range cycle.deltas {
+		delta := &cycle.deltas[i]
+		key := delta.Keys[len(delta.Keys)-1]
+		version := delta.Version
+		if version == 0 && !delta.Delete && m.conf.GetVersionFromV != nil {
+			version = m.conf.GetVersionFromV(m.view(delta.Data))
+		}
+		if version != 0 {
+			latest, ok := versions[key]
+			if !ok {
+				latest = m.entryVersion(leaf, key)
+			}
+			if version <= latest {
+				m.stale++
+				continue
+			}
+		}
+		versions[key] = version
+		prev, ok := pending[key]
+		if !ok {
+			keys = append(keys, key)
+		} else if delta.Patch && cycle.deltas[prev].Delete {
+			delta.Patch = false // nothing left to patch
+		} else if delta.Patch {
+			delta.Data = m.overlay(cycle.deltas[prev].Data, delta.Data)
+			delta.Patch = cycle.deltas[prev].Patch
+		}
+		pending[key] = i
+	}
+
+	for key := range cycle.deleted {
+		if _, ok := pending[key]; !ok {
+			m.remove(leaf, key)
+		}
+	}
+	for _, version := range versions {
+		leaf.maxVersion = max(leaf.maxVersion, version)
+	}
+	for _, key := range keys {
+		delta, version := cycle.deltas[pending[key]], versions[key]
+		delete(leaf.tombstones, key)
+		if delta.Delete {
+			m.bury(leaf, key, version)
+			continue
+		}
+		data := slices.Clone(delta.Data)
+		old, exists := leaf.entries[key]
+		if _, deleted := cycle.deleted[key]; delta.Patch && exists && !deleted {
+			data = m.overlay(old.data, data)
+		}
+		v := m.view(data)
+		if m.conf.CheckVForDelete != nil && m.conf.CheckVForDelete(v) {
+			m.bury(leaf, key, version)
+			continue
+		}
+		if m.conf.GetVersionFromV != nil {
+			version = m.conf.GetVersionFromV(v) // a patch may not carry the version
+		}
+		m.remove(leaf, key)
+		leaf.entries[key] = memoryEntry{data: data, version: version}
+		leaf.order = append(leaf.order, key)
+	}
+	m.trimTombstones(leaf)
+}
+
+// entryVersion returns the version of the entry at key, or of the delete that removed it.
+func (m *MemoryMap[K, VT, V, VList]) entryVersion(leaf *memoryNode[K], key K) uint64 {
+	if entry, ok := leaf.entries[key]; ok {
+		return entry.version
+	}
+	return leaf.tombstones[key]
+}
+
+// overlay returns base with the fields present in patch replaced.
+func (m *MemoryMap[K, VT, V, VList]) overlay(base, patch []byte) []byte {
+	vt := m.view(base).UnPack()
+	m.merge(vt, m.view(patch))
+	return packV(vt)
+}
+
+// bury removes the entry at key, a versioned removal leaves a tombstone so that older writes
+// stay stale.
+func (m *MemoryMap[K, VT, V, VList]) bury(leaf *memoryNode[K], key K, version uint64) {
+	m.remove(leaf, key)
+	if version == 0 {
+		return
+	}
+	if leaf.tombstones == nil {
+		leaf.tombstones = make(map[K]uint64)
+	}
+	leaf.tombstones[key] = version
+}
+
+// trimTombstones drops the tombstones more than FlatConfig.TombstoneWindow behind the newest
+// version of the leaf, as mergeTombstones does.
+func (m *MemoryMap[K, VT, V, VList]) trimTombstones(leaf *memoryNode[K]) {
+	window := m.conf.TombstoneWindow
+	if window == 0 {
+		return
+	}
+	maps.DeleteFunc(leaf.tombstones, func(_ K, version uint64) bool {
+		return leaf.maxVersion-version > window
+	})
+}
+
+// view returns a V reading data.
+func (m *MemoryMap[K, VT, V, VList]) view(data []byte) V {
+	v := m.conf.NewV()
+	v.Init(data, flatbuffers.GetUOffsetT(data))
+	return v
+}
+
+func (m *MemoryMap[K, VT, V, VList]) remove(leaf *memoryNode[K], key K) {
+	if _, ok := leaf.entries[key]; !ok {
+		return
+	}
+	delete(leaf.entries, key)
+	leaf.order = slices.DeleteFunc(leaf.order, func(k K) bool { return k == key })
+}
+
+// makeLeaf returns the leaf for the entry at keys, creating the nodes on its path.
+func (m *MemoryMap[K, VT, V, VList]) makeLeaf(keys []K) *memoryNode[K] {
+	node := &m.root
+	for _, key := range keys[:len(keys)-1] {
+		if node.children == nil {
+			node.children = make(map[K]*memoryNode[K])
+		}
+		child, ok := node.children[key]
+		if !ok {
+			child = &memoryNode[K]{}
+			node.children[key] = child
+		}
+		node = child
+	}
+	if node.entries == nil {
+		node.entries = make(map[K]memoryEntry)
+	}
+	return node
+}
+
+// leaf returns the leaf holding the entry at keys, nil when there is none.
+func (m *MemoryMap[K, VT, V, VList]) leaf(keys []K) *memoryNode[K] {
+	if len(keys) == 0 {
+		return nil
+	}
+	node, depth := m.shard(keys)
+	if node == nil || depth != len(keys)-1 {
+		return nil
+	}
+	return node
+}
+
+// shard descends along keys to the first leaf, it returns the leaf and its depth.
+func (m *MemoryMap[K, VT, V, VList]) shard(keys []K) (*memoryNode[K], int) {
+	node := &m.root
+	for depth := 0; ; depth++ {
+		if node.entries != nil {
+			return node, depth
+		}
+		if depth == len(keys) {
+			return nil, depth
+		}
+		child, ok := node.children[keys[depth]]
+		if !ok {
+			return nil, depth
+		}
+		node = child
+	}
+}
+
+func (m *MemoryMap[K, VT, V, VList]) collect(node *memoryNode[K], prefix []K, depth int, datas *[][]byte) {
+	if node.entries == nil {
+		if depth < len(prefix) {
+			if child, ok := node.children[prefix[depth]]; ok {
+				m.collect(child, prefix, depth+1, datas)
+			}
+			return
+		}
+		for _, child := range node.children {
+			m.collect(child, prefix, depth+1, datas)
+		}
+		return
+	}
+	if depth < len(prefix) {
+		if entry, ok := node.entries[prefix[depth]]; ok {
+			*datas = append(*datas, entry.data)
+		}
+		return
+	}
+	for _, key := range m.orderedKeys(node) {
+		*datas = append(*datas, node.entries[key].data)
+	}
+}
+
+func (m *MemoryMap[K, VT, V, VList]) orderedKeys(node *memoryNode[K]) []K {
+	if !m.conf.SortedLayout {
+		return node.order
+	}
+	return slices.SortedFunc(slices.Values(node.order), m.conf.CompareKeys)
+}
+
+// shardBuffer packs the entries of a leaf into a buffer laid out as a FlatNode leaf.
+func (m *MemoryMap[K, VT, V, VList]) shardBuffer(node *memoryNode[K]) []byte {
+	keys := m.orderedKeys(node)
+	builder := flatbuffers.NewBuilder(1024)
+	offsets := make([]flatbuffers.UOffsetT, len(keys))
+	v := m.conf.NewV()
+	for i, key := range keys {
+		data := node.entries[key].data
+		v.Init(data, flatbuffers.GetUOffsetT(data))
+		offsets[i] = v.UnPack().Pack(builder)
+	}
+	builder.StartVector(flatbuffers.SizeUOffsetT, len(offsets), flatbuffers.SizeUOffsetT)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		builder.PrependUOffsetT(offsets[i])
+	}
+	children := builder.EndVector(len(offsets))
+	builder.StartObject(1)
+	builder.PrependUOffsetTSlot(0, children, 0)
+	builder.Finish(builder.EndObject())
+	return builder.FinishedBytes()
+}
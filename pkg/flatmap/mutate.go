@@ -0,0 +1,103 @@
+package flatmap
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MutateInPlace applies a scalar-only mutation, e.g. the generated MutatePageCount, to the
+// entry at keys without a rebuild: mutate runs on a copy of the shard buffer, which is
+// published as the new view when it returns true. The copies alternate between two buffers
+// the shard recycles, so a mutation allocates nothing; a buffer still read by a transaction
+// or retained history is replaced instead. Values read with a plain Get from a mutated view
+// keep their content until the second mutation of the shard after it, the buffers written by
+// rebuilds or restored from snapshots are never written.
+//
+// Flatbuffers cannot mutate a field that is absent from the table (default values are not
+// serialized), mutate reports that by returning false. In that case the copy is discarded
+// and fallback, when provided, is queued as a normal delta applied at the next rebuild.
+// Deltas for the same entry that are still pending win over the in-place mutation.
+func (sn *FlatNode[K, VT, V, VList]) MutateInPlace(keys []K, mutate func(v V) bool, fallback func(vt VT)) (inPlace bool, err error) {
+	if len(keys) == 0 {
+		return false, fmt.Errorf("no keys provided")
+	}
+	if sn.nodeType == NodeNonLeaf {
+		child, ok := sn.children[keys[sn.level]]
+		if !ok {
+			return false, fmt.Errorf("key not found")
+		}
+		return child.MutateInPlace(keys, mutate, fallback)
+	}
+	if sn.nodeType != NodeLeaf {
+		return false, fmt.Errorf("key not found")
+	}
+
+	sn.rwMutex.Lock()
+	inPlace, err = sn.mutateLeaf(keys[sn.level], mutate)
+	sn.rwMutex.Unlock()
+	if err != nil || inPlace {
+		return inPlace, err
+	}
+	if fallback == nil {
+		return false, fmt.Errorf("in-place mutation failed")
+	}
+	return false, sn.enqueueDeferred(deferredDelta[K, VT, V]{
+		delta: DeltaItem[K]{Keys: keys},
+		check: func(_ V, exists bool, _ uint64) bool {
+			return exists
+		},
+		merge: func(old VT) VT {
+			fallback(old)
+			return old
+		},
+	})
+}
+
+func (sn *FlatNode[K, VT, V, VList]) mutateLeaf(key K, mutate func(v V) bool) (bool, error) {
+	view := sn.viewPtr.Load() // never nil
+	vObj := sn.conf.NewV()
+	index, ok := sn.lookup(view, key, vObj)
+	if !ok {
+		return false, fmt.Errorf("key not found")
+	}
+	if _, deleted := sn.deleted[key]; deleted {
+		return false, fmt.Errorf("key not found")
+	}
+
+	// mutate a copy in the write buffer, the rebuilt or restored buffer stays untouched
+	buffer := append(sn.claimWriteBuffer(), sn.ReadBuffer...)
+	vList := sn.leafList(buffer)
+	if !vList.Children(vObj, index) {
+		return false, nil
+	}
+	indexKeys := sn.indexKeysOf(vObj)
+	if !mutate(vObj) {
+		return false, nil
+	}
+	sn.ReadBuffer = buffer
+	sn.WriteBuffer, sn.BackupBuffer = sn.BackupBuffer, buffer // reused by the mutation after next
+
+	indexes := view.indexes // unchanged, views never modify their indexes
+	if embedded := sn.embeddedKeyIndex(buffer); embedded != nil {
+		indexes = embedded // lets the old buffer go
+	}
+	newView := &View[K, VT, V, VList]{
+		indexes:    indexes,
+		Vlist:      vList,
+		versions:   view.versions,
+		maxVersion: view.maxVersion,
+		tombstones: view.tombstones,
+		secondary:  view.secondary,
+		sorted:     view.sorted,
+		filter:     view.filter,
+		buffer:     buffer,
+
+		floorVersion: view.floorVersion,
+	}
+	// the keys and positions are unchanged, only a mutated indexed value needs a new index
+	if !slices.EqualFunc(indexKeys, sn.indexKeysOf(vObj), slices.Equal) {
+		sn.buildSecondaryIndexes(newView)
+	}
+	sn.commitView(newView)
+	return true, nil
+}
@@ -0,0 +1,124 @@
+package flatmap_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestMutateInPlace(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 0), bookDelta(1, 2, 0, 0)})
+
+	inPlace, err := node.MutateInPlace([]int{1}, func(b *books.Book) bool { return b.MutatePageCount(11) }, nil)
+	if !inPlace || err != nil {
+		t.Fatalf("MutateInPlace = %v, %v, want true, nil", inPlace, err)
+	}
+	expectPageCount(t, node, []int{1}, 11)
+
+	// a zero page count is not serialized, the fallback is applied by the next rebuild
+	inPlace, err = node.MutateInPlace([]int{2},
+		func(b *books.Book) bool { return b.MutatePageCount(5) },
+		func(bt *books.BookT) { bt.PageCount = 5 },
+	)
+	if inPlace || err != nil {
+		t.Fatalf("MutateInPlace = %v, %v, want false, nil", inPlace, err)
+	}
+	expectPageCount(t, node, []int{2}, 0)
+	node.Update(nil)
+	expectPageCount(t, node, []int{2}, 5)
+
+	if _, err := node.MutateInPlace([]int{9}, func(*books.Book) bool { return true }, nil); err == nil {
+		t.Fatal("MutateInPlace of a missing key succeeded")
+	}
+}
+
+func TestMutateInPlaceKeepsHeldValues(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 100, 0)})
+	held := &books.Book{}
+	if !node.Get([]int{1}, held) {
+		t.Fatal("book 1 is missing")
+	}
+	for i := range uint64(3) {
+		node.MutateInPlace([]int{1}, func(b *books.Book) bool { return b.MutatePageCount(200 + i) }, nil)
+	}
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 2, 1, 0)}) // a rebuild in between
+	node.MutateInPlace([]int{1}, func(b *books.Book) bool { return b.MutatePageCount(203) }, nil)
+	if got := held.PageCount(); got != 100 {
+		t.Fatalf("held page count = %d after mutations, want 100", got)
+	}
+	expectPageCount(t, node, []int{1}, 203)
+}
+
+func TestMutateInPlaceRecyclesBuffers(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	deltas := make([]flatmap.DeltaItem[int], 0, 1000)
+	for id := uint64(1); id <= 1000; id++ {
+		deltas = append(deltas, bookDelta(1, id, id, 0))
+	}
+	node.FeedDeltaBulk(deltas)
+	size := len(node.GetSnapshot([]int{1}, false).Buffer)
+	mutate := func(pages uint64) {
+		node.MutateInPlace([]int{1}, func(b *books.Book) bool { return b.MutatePageCount(pages) }, nil)
+	}
+
+	txn := node.BeginRead()
+	mutate(2)
+	held := &books.Book{}
+	txn.Get([]int{1}, held)
+	for pages := range uint64(5) {
+		mutate(10 + pages)
+	}
+	if got := held.PageCount(); got != 1 {
+		t.Fatalf("page count read in the transaction = %d after mutations, want 1", got)
+	}
+	txn.Close()
+
+	mutate(20) // the buffers read by the transaction are let go
+	mutate(21)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for pages := range uint64(100) {
+		mutate(100 + pages)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(10*size) {
+		t.Fatalf("100 mutations allocated %d bytes, the shard is %d bytes", allocated, size)
+	}
+	expectPageCount(t, node, []int{1}, 199)
+}
+
+func TestMutateInPlaceKeepsDerivedState(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.CompareKeys = func(a, b int) int { return a - b }
+	conf.FilterFalsePositiveRate = 0.01
+	conf.Indexes = []flatmap.IndexDef[*books.Book]{
+		flatmap.NewIndex("pages", func(b *books.Book) []uint64 { return []uint64{b.PageCount()} }),
+	}
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 3, 10, 0), bookDelta(1, 1, 10, 0), bookDelta(1, 2, 20, 0)})
+
+	node.MutateInPlace([]int{1}, func(b *books.Book) bool { return b.MutatePageCount(20) }, nil)
+	if found, err := node.GetBy("pages", uint64(20)); err != nil || len(found) != 2 {
+		t.Fatalf("GetBy(pages, 20) found %d values, err %v, want 2", len(found), err)
+	}
+	if found, _ := node.GetBy("pages", uint64(10)); len(found) != 1 || found[0].Id() != 3 {
+		t.Fatalf("GetBy(pages, 10) found %d values, want book 3", len(found))
+	}
+	var keys []int
+	for key := range node.Ascend(nil) {
+		keys = append(keys, key)
+	}
+	if len(keys) != 3 || keys[0] != 1 || keys[2] != 3 {
+		t.Fatalf("Ascend keys = %v, want [1 2 3]", keys)
+	}
+	if !node.Has([]int{2}) || node.Has([]int{4}) {
+		t.Fatal("Has does not match the keys after a mutation")
+	}
+	if snapshot := node.GetSnapshot([]int{1}, false); len(snapshot.Filter) == 0 {
+		t.Fatal("the mutated view dropped its bloom filter")
+	}
+}
@@ -0,0 +1,187 @@
+package flatmap
+
+import (
+	"container/heap"
+	"iter"
+	"slices"
+	"sort"
+)
+
+// Ascend iterates the entries under prefix in ascending key order, merging the shards.
+// Keys are compared at the leaf level with FlatConfig.CompareKeys, nothing is yielded when
+// it is not set. An iteration reads the version committed when it starts, pinned until it
+// ends. The yielded V is only valid until the next step.
+func (sn *FlatNode[K, VT, V, VList]) Ascend(prefix []K) iter.Seq2[K, V] {
+	return sn.ordered(prefix, nil, nil, false)
+}
+
+// Descend iterates the entries under prefix in descending key order, see Ascend.
+func (sn *FlatNode[K, VT, V, VList]) Descend(prefix []K) iter.Seq2[K, V] {
+	return sn.ordered(prefix, nil, nil, true)
+}
+
+// Range iterates the entries under prefix whose key is in [from, to), in ascending order,
+// see Ascend.
+func (sn *FlatNode[K, VT, V, VList]) Range(prefix []K, from, to K) iter.Seq2[K, V] {
+	return sn.ordered(prefix, &from, &to, false)
+}
+
+// ordered pins the latest committed version for each iteration, the views it walks would
+// otherwise be recycled by the rebuilds running meanwhile.
+func (sn *FlatNode[K, VT, V, VList]) ordered(prefix []K, from, to *K, descending bool) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		txn := sn.BeginRead()
+		defer txn.Close()
+		sn.orderedAt(prefix, from, to, descending, txn.version)(yield)
+	}
+}
+
+func (sn *FlatNode[K, VT, V, VList]) orderedAt(prefix []K, from, to *K, descending bool, version uint64) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		if sn.conf.CompareKeys == nil {
+			sn.logf(ErrorLevel, "%s ordered iteration needs FlatConfig.CompareKeys\n", sn.conf.Name)
+			return
+		}
+		cursors := &cursorHeap[K, VT, V, VList]{compare: sn.conf.CompareKeys, descending: descending}
+		sn.collectCursors(prefix, from, to, descending, version, &cursors.items)
+		heap.Init(cursors)
+		for cursors.Len() > 0 {
+			cursor := cursors.items[0]
+			if cursor.view.Vlist.Children(cursor.v, cursor.index) && !yield(cursor.key, cursor.v) {
+				return
+			}
+			if cursor.advance() {
+				heap.Fix(cursors, 0)
+			} else {
+				heap.Pop(cursors)
+			}
+		}
+	}
+}
+
+func (sn *FlatNode[K, VT, V, VList]) collectCursors(prefix []K, from, to *K, descending bool, version uint64, cursors *[]*keyCursor[K, VT, V, VList]) {
+	switch sn.nodeType {
+	case NodeNonLeaf:
+		if len(prefix) > sn.level {
+			if child, ok := sn.children[prefix[sn.level]]; ok {
+				child.collectCursors(prefix, from, to, descending, version, cursors)
+			}
+			return
+		}
+		for _, child := range sn.children {
+			child.collectCursors(prefix, from, to, descending, version, cursors)
+		}
+	case NodeLeaf:
+		cursor := &keyCursor[K, VT, V, VList]{node: sn, view: sn.viewAt(version), v: sn.conf.NewV(), descending: descending}
+		compare := sn.conf.CompareKeys
+		keyAt := func(p int) K {
+			key, _ := sn.sortedKeyAt(cursor.view, p, cursor.v)
+			return key
+		}
+		single := len(prefix) > sn.level // the prefix selects a single entry
+		if single {
+			from, to = &prefix[sn.level], nil
+		}
+		lo, hi := 0, len(cursor.view.sorted)
+		if sn.conf.SortedLayout {
+			hi = cursor.view.length()
+		}
+		if from != nil {
+			lo = sort.Search(hi, func(p int) bool { return compare(keyAt(p), *from) >= 0 })
+		}
+		if to != nil {
+			hi = sort.Search(hi, func(p int) bool { return compare(keyAt(p), *to) >= 0 })
+		}
+		if single && lo < hi {
+			if compare(keyAt(lo), *from) != 0 {
+				return
+			}
+			hi = lo + 1
+		}
+		if lo < hi {
+			cursor.lo, cursor.hi = lo, hi
+			cursor.load()
+			*cursors = append(*cursors, cursor)
+		}
+	}
+}
+
+// sortedKeyAt returns the key at position p of the sorted keys of a leaf view and the index
+// of its child in the buffer.
+func (sn *FlatNode[K, VT, V, VList]) sortedKeyAt(view *View[K, VT, V, VList], p int, probe V) (K, int) {
+	if sn.conf.SortedLayout {
+		return sn.keyAt(view, p, probe), p
+	}
+	key := view.sorted[p]
+	index, _ := view.indexes.Lookup(key)
+	return key, index
+}
+
+// buildSortedKeys sorts the keys of a view about to be published. A sorted layout is
+// already in key order.
+func (sn *FlatNode[K, VT, V, VList]) buildSortedKeys(view *View[K, VT, V, VList]) {
+	if sn.conf.CompareKeys == nil || sn.conf.SortedLayout {
+		return
+	}
+	view.sorted = sn.viewKeys(view)
+	slices.SortFunc(view.sorted, sn.conf.CompareKeys)
+}
+
+// keyCursor walks the positions [lo, hi) of the sorted keys of one leaf view.
+type keyCursor[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
+	node       *FlatNode[K, VT, V, VList]
+	view       *View[K, VT, V, VList]
+	lo, hi     int
+	pos        int
+	descending bool
+	key        K   // current key
+	index      int // child index of the current key
+	v          V
+}
+
+func (c *keyCursor[K, VT, V, VList]) load() {
+	p := c.lo + c.pos
+	if c.descending {
+		p = c.hi - 1 - c.pos
+	}
+	c.key, c.index = c.node.sortedKeyAt(c.view, p, c.v)
+}
+
+// advance moves to the next key, it reports false once the cursor is exhausted.
+func (c *keyCursor[K, VT, V, VList]) advance() bool {
+	c.pos++
+	if c.lo+c.pos == c.hi {
+		return false
+	}
+	c.load()
+	return true
+}
+
+// cursorHeap orders the leaf cursors by their current key for the k-way merge.
+type cursorHeap[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
+	items      []*keyCursor[K, VT, V, VList]
+	compare    func(a, b K) int
+	descending bool
+}
+
+func (h *cursorHeap[K, VT, V, VList]) Len() int { return len(h.items) }
+
+func (h *cursorHeap[K, VT, V, VList]) Less(i, j int) bool {
+	c := h.compare(h.items[i].key, h.items[j].key)
+	if h.descending {
+		return c > 0
+	}
+	return c < 0
+}
+
+func (h *cursorHeap[K, VT, V, VList]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *cursorHeap[K, VT, V, VList]) Push(x any) {
+	h.items = append(h.items, x.(*keyCursor[K, VT, V, VList]))
+}
+
+func (h *cursorHeap[K, VT, V, VList]) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
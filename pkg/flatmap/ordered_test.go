@@ -0,0 +1,88 @@
+package flatmap_test
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func collectKeys(seq func(yield func(int, *books.Book) bool), limit int) []int {
+	var keys []int
+	for key := range seq {
+		keys = append(keys, key)
+		if len(keys) == limit {
+			break
+		}
+	}
+	return keys
+}
+
+func TestOrderedIteration(t *testing.T) {
+	conf := newBookConfig(2)
+	conf.CompareKeys = cmp.Compare[int]
+	node := flatmap.NewFlatNode(conf, 0)
+	var deltas []flatmap.DeltaItem[int]
+	var ids []int
+	for id := 100; id > 0; id -= 3 {
+		deltas = append(deltas, bookDelta(2, uint64(id), uint64(id), 0))
+		ids = append(ids, id)
+	}
+	node.FeedDeltaBulk(deltas)
+	slices.Sort(ids)
+
+	var ascending []int
+	for key, book := range node.Ascend(nil) {
+		if book.PageCount() != uint64(key) {
+			t.Fatalf("key %d yielded book %d", key, book.Id())
+		}
+		ascending = append(ascending, key)
+	}
+	if !slices.Equal(ascending, ids) {
+		t.Fatalf("Ascend = %v, want %v", ascending, ids)
+	}
+	if top := collectKeys(node.Descend(nil), 3); !slices.Equal(top, []int{100, 97, 94}) {
+		t.Fatalf("Descend = %v, want [100 97 94]", top)
+	}
+	if keys := collectKeys(node.Range(nil, 10, 20), 0); !slices.Equal(keys, []int{10, 13, 16, 19}) {
+		t.Fatalf("Range(10, 20) = %v, want [10 13 16 19]", keys)
+	}
+	if keys := collectKeys(node.Ascend([]int{1}), 0); !slices.Equal(keys, []int{1, 31, 61, 91}) {
+		t.Fatalf("Ascend([1]) = %v, want [1 31 61 91]", keys)
+	}
+	if keys := collectKeys(node.Ascend([]int{1, 31}), 0); !slices.Equal(keys, []int{31}) {
+		t.Fatalf("Ascend([1 31]) = %v, want [31]", keys)
+	}
+	if keys := collectKeys(node.Ascend([]int{1, 32}), 0); len(keys) != 0 {
+		t.Fatalf("Ascend([1 32]) = %v, want none", keys)
+	}
+}
+
+func TestOrderedIterationIsPinned(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.CompareKeys = cmp.Compare[int]
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 1, 0), bookDelta(1, 2, 2, 0), bookDelta(1, 3, 3, 0)})
+
+	seq := node.Ascend(nil)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 4, 4, 0)}) // the iteration starts after
+	var keys []int
+	for key, book := range seq {
+		if book.PageCount() != uint64(key) {
+			t.Fatalf("key %d read %d pages, rebuilt under the iteration", key, book.PageCount())
+		}
+		keys = append(keys, key)
+		if key == 1 {
+			for i := uint64(1); i <= 3; i++ { // recycles every unpinned buffer
+				node.FeedDeltaBulk([]flatmap.DeltaItem[int]{
+					bookDelta(1, 1, 100*i, 0), bookDelta(1, 2, 100*i, 0), bookDelta(1, 3, 100*i, 0),
+				})
+			}
+		}
+	}
+	if !slices.Equal(keys, []int{1, 2, 3, 4}) {
+		t.Fatalf("Ascend = %v, want [1 2 3 4]", keys)
+	}
+}
@@ -0,0 +1,128 @@
+package flatmap
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+var errNoMergePatch = fmt.Errorf("patch deltas need MergePatch or Schema")
+
+// checkPatch rejects a patch delta the config cannot merge.
+func (sn *FlatNode[K, VT, V, VList]) checkPatch(delta *DeltaItem[K]) error {
+	if delta.Patch && sn.conf.mergePatch == nil {
+		return errNoMergePatch
+	}
+	return nil
+}
+
+// overlayPatch returns base with the fields present in patch replaced, serialized on its own.
+// Patch deltas are dropped before reaching it when the config has no merge, see mergePatch.
+func (sn *FlatNode[K, VT, V, VList]) overlayPatch(base V, patch V) []byte {
+	vt := base.UnPack()
+	sn.conf.mergePatch(vt, patch)
+	return packV(vt)
+}
+
+// foldPatch merges the patch at pendingDelta[i] over the delta at pendingDelta[prev] for the
+// same key, so that several patches queued in one cycle compose instead of replacing each other.
+func (sn *FlatNode[K, VT, V, VList]) foldPatch(prev, i int, base, patch V) {
+	sn.GetRootAsV(sn.pendingDelta[prev].Data, base)
+	sn.GetRootAsV(sn.pendingDelta[i].Data, patch)
+	sn.pendingDelta[i].Data = sn.overlayPatch(base, patch)
+	sn.pendingDelta[i].Patch = sn.pendingDelta[prev].Patch
+}
+
+// resolveMergePatch returns FlatConfig.MergePatch, or a merge resolved through
+// FlatConfig.Schema, nil when patch deltas cannot be applied.
+func (fc *FlatConfig[K, VT, V, VList]) resolveMergePatch() func(dst VT, patch V) {
+	if fc.MergePatch != nil {
+		return fc.MergePatch
+	}
+	if fc.Schema == nil {
+		return nil // the vtable slot of an object API field cannot be proven
+	}
+	merge, _ := schemaMergePatch[VT, V](fc.Schema) // reported by Validate
+	return merge
+}
+
+// schemaMergePatch returns a merge copying the object API fields of the table fields present
+// in the patch. Fields are matched by their json tag, which flatc sets to the schema name, or
+// by the name flatc gives them; a union is copied with its type, deprecated fields are skipped.
+func schemaMergePatch[VT VTypeT, V VType[VT]](table *SchemaObject) (func(dst VT, patch V), error) {
+	typ := reflect.TypeFor[VT]()
+	if typ.Kind() != reflect.Pointer || typ.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a pointer to an object API struct", typ)
+	}
+	typ = typ.Elem()
+	type patchField struct {
+		slot  flatbuffers.VOffsetT
+		index int
+	}
+	fields := make([]patchField, 0, len(table.Fields))
+	for _, field := range table.Fields {
+		if field.Deprecated || field.Type.Base == BaseTypeUType {
+			continue
+		}
+		index := objectField(typ, field.Name)
+		if index < 0 {
+			return nil, fmt.Errorf("%s has no field for %s.%s", typ, table.Name, field.Name)
+		}
+		fields = append(fields, patchField{slot: flatbuffers.VOffsetT(field.Offset), index: index})
+	}
+	return func(dst VT, patch V) {
+		src := reflect.ValueOf(patch.UnPack()).Elem()
+		dest := reflect.ValueOf(dst).Elem()
+		tab := patch.Table()
+		for _, field := range fields {
+			if tab.Offset(field.slot) == 0 {
+				continue // absent in the patch, keep the old value
+			}
+			dest.Field(field.index).Set(src.Field(field.index))
+		}
+	}, nil
+}
+
+// objectField returns the index of the object API field of the schema field name, -1 if none.
+func objectField(typ reflect.Type, name string) int {
+	goName := fieldGoName(name)
+	byName := -1
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == name {
+			return i
+		}
+		if field.Name == goName {
+			byName = i
+		}
+	}
+	return byName
+}
+
+// fieldGoName converts a schema field name the way flatc names object API fields.
+func fieldGoName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// packV serializes vt into its own buffer.
+func packV[VT VTypeT](vt VT) []byte {
+	builder := flatbuffers.NewBuilder(256)
+	builder.Finish(vt.Pack(builder))
+	return builder.FinishedBytes()
+}
@@ -0,0 +1,124 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+// reorderedBooks declares the fields of books.Book out of order, their slots set by id.
+const reorderedBooks = `
+namespace books;
+table Book {
+	rate: double (id: 3);
+	title: string (id: 1);
+	scalar_list_field: [ulong] (id: 5);
+	id: ulong (id: 0);
+	list_field: [string] (id: 4);
+	page_count: ulong (id: 2);
+}
+root_type Book;
+`
+
+func patchDelta(id uint64, build func(builder *flatbuffers.Builder)) flatmap.DeltaItem[int] {
+	builder := flatbuffers.NewBuilder(64)
+	books.BookStart(builder)
+	build(builder)
+	builder.Finish(books.BookEnd(builder))
+	return flatmap.DeltaItem[int]{Keys: []int{int(id)}, Data: builder.FinishedBytes(), Patch: true}
+}
+
+func TestPatchDeltas(t *testing.T) {
+	for _, source := range []string{"books.fbs", "reordered"} {
+		var schema *flatmap.Schema
+		var err error
+		if source == "books.fbs" {
+			schema, err = flatmap.LoadSchema("../../example/books.fbs")
+		} else {
+			schema, err = flatmap.ParseSchema(reorderedBooks)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := newBookConfig(1)
+		conf.Schema = schema.Object("books.Book")
+		if err := conf.Validate(); err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		node := flatmap.NewFlatNode(conf, 0)
+		node.FeedDeltaBulk([]flatmap.DeltaItem[int]{{
+			Keys: []int{1},
+			Data: packBook(&books.BookT{Id: 1, Title: "title", PageCount: 10, Rate: 1.5}),
+		}})
+
+		// both patches of the cycle compose with the merge queued after them
+		if err := node.Set(patchDelta(1, func(b *flatbuffers.Builder) { books.BookAddPageCount(b, 77) })); err != nil {
+			t.Fatal(err)
+		}
+		node.Set(patchDelta(1, func(b *flatbuffers.Builder) { books.BookAddRate(b, 9.5) }))
+		node.Patch([]int{1}, func(old *books.BookT) *books.BookT {
+			old.Title += "+"
+			return old
+		})
+		node.Update(nil)
+
+		book := &books.Book{}
+		if !node.Get([]int{1}, book) {
+			t.Fatalf("%s: patched book is missing", source)
+		}
+		got := book.UnPack()
+		if got.Id != 1 || got.PageCount != 77 || got.Rate != 9.5 || got.Title != "title+" {
+			t.Fatalf("%s: patched book = %+v", source, got)
+		}
+	}
+}
+
+func TestPatchCreatesEntry(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.Patch([]int{2}, func(old *books.BookT) *books.BookT {
+		if old != nil {
+			t.Errorf("merge of a missing entry got %+v", old)
+		}
+		return &books.BookT{Id: 2, PageCount: 1}
+	})
+	node.Update(nil)
+	expectPageCount(t, node, []int{2}, 1)
+}
+
+func TestPatchNeedsSchemaOrMergePatch(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 0)})
+	patch := patchDelta(1, func(b *flatbuffers.Builder) { books.BookAddRate(b, 9.5) })
+	if err := node.Set(patch); err == nil {
+		t.Fatal("Set accepted a patch delta without MergePatch or Schema")
+	}
+	if err := node.ApplyBatch([]flatmap.DeltaItem[int]{patch}); err == nil {
+		t.Fatal("ApplyBatch accepted a patch delta without MergePatch or Schema")
+	}
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{patch})
+	expectPageCount(t, node, []int{1}, 10) // dropped, not stored as a whole entry
+
+	conf := newBookConfig(1)
+	conf.MergePatch = func(dst *books.BookT, patch *books.Book) { dst.Rate = patch.Rate() }
+	node = flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 0)})
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{patch})
+	book := &books.Book{}
+	if !node.Get([]int{1}, book) || book.Rate() != 9.5 || book.PageCount() != 10 {
+		t.Fatalf("MergePatch result = %+v", book.UnPack())
+	}
+}
+
+func TestPatchSchemaMismatch(t *testing.T) {
+	schema, err := flatmap.ParseSchema("namespace books; table Book { id: ulong; isbn: string; } root_type Book;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := newBookConfig(1)
+	conf.Schema = schema.Object("books.Book")
+	if err := conf.Validate(); err == nil {
+		t.Fatal("Validate accepted a schema with a field missing from the object API")
+	}
+}
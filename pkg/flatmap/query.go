@@ -0,0 +1,210 @@
+package flatmap
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// queryCheckInterval is the number of rows scanned between two context checks.
+const queryCheckInterval = 256
+
+// Query scans the entries under a prefix, see FlatNode.Query. Shards are scanned in
+// parallel on the version committed when the scan starts, pinned until it ends, or on the
+// views of the transaction that created the query, with one V per worker and no allocation
+// per row.
+type Query[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
+	root    *FlatNode[K, VT, V, VList]
+	prefix  []K
+	where   []func(v V) bool
+	limit   int
+	version uint64
+	pinned  bool // version is set by a read transaction
+}
+
+// Reducer folds the matching entries of a query into an accumulator, see Aggregate. Each
+// worker starts from Init, Reduce must not retain v and Merge combines two workers.
+type Reducer[A any, V any] struct {
+	Init   func() A
+	Reduce func(acc A, v V) A
+	Merge  func(a, b A) A
+}
+
+// Query starts a query over the entries under prefix, all the entries when it is empty.
+func (sn *FlatNode[K, VT, V, VList]) Query(prefix []K) *Query[K, VT, V, VList] {
+	return &Query[K, VT, V, VList]{root: sn, prefix: prefix}
+}
+
+// Query starts a query over the entries under prefix as of the transaction version.
+func (txn *ReadTxn[K, VT, V, VList]) Query(prefix []K) *Query[K, VT, V, VList] {
+	return &Query[K, VT, V, VList]{root: txn.root, prefix: prefix, version: txn.version, pinned: true}
+}
+
+// Where keeps the entries for which pred holds, on top of the previous conditions. pred is
+// called concurrently from several goroutines.
+func (q *Query[K, VT, V, VList]) Where(pred func(v V) bool) *Query[K, VT, V, VList] {
+	q.where = append(q.where, pred)
+	return q
+}
+
+// Limit stops the query after n matching entries, 0 means no limit. With several shards
+// scanned in parallel, which entries make the cut is not defined.
+func (q *Query[K, VT, V, VList]) Limit(n int) *Query[K, VT, V, VList] {
+	q.limit = n
+	return q
+}
+
+// Each calls fn for every matching entry until fn returns false. fn is called concurrently,
+// one goroutine per scanned shard at a time, and v is only valid during the call. When ctx
+// is done the scan stops and ctx.Err() is returned.
+func (q *Query[K, VT, V, VList]) Each(ctx context.Context, fn func(v V) bool) error {
+	return q.scan(ctx, func() (func(v V) bool, func()) {
+		return fn, func() {}
+	})
+}
+
+// Count returns the number of matching entries, the partial count with ctx.Err() when ctx
+// is done first.
+func (q *Query[K, VT, V, VList]) Count(ctx context.Context) (int, error) {
+	var total atomic.Int64
+	err := q.scan(ctx, func() (func(v V) bool, func()) {
+		var count int64
+		return func(V) bool {
+				count++
+				return true
+			}, func() {
+				total.Add(count)
+			}
+	})
+	return int(total.Load()), err
+}
+
+// Aggregate reduces the matching entries of q, the partial result with ctx.Err() when ctx
+// is done first.
+func Aggregate[A any, K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]](
+	ctx context.Context,
+	q *Query[K, VT, V, VList],
+	r Reducer[A, V],
+) (A, error) {
+	var mu sync.Mutex
+	result := r.Init()
+	err := q.scan(ctx, func() (func(v V) bool, func()) {
+		acc := r.Init()
+		return func(v V) bool {
+				acc = r.Reduce(acc, v)
+				return true
+			}, func() {
+				mu.Lock()
+				result = r.Merge(result, acc)
+				mu.Unlock()
+			}
+	})
+	return result, err
+}
+
+// queryRange is a range of children positions of a leaf view to scan.
+type queryRange[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
+	view   *View[K, VT, V, VList]
+	lo, hi int
+}
+
+// scan runs the query with one worker per CPU at most. newWorker is called once per worker
+// and returns the visitor of the matching entries, which stops everything by returning
+// false, and the function called when the worker is done.
+func (q *Query[K, VT, V, VList]) scan(ctx context.Context, newWorker func() (visit func(v V) bool, done func())) error {
+	version := q.version
+	if !q.pinned { // pin the latest version while its views are scanned
+		txn := q.root.BeginRead()
+		defer txn.Close()
+		version = txn.version
+	}
+	var ranges []queryRange[K, VT, V, VList]
+	q.root.collectQueryRanges(q.prefix, version, &ranges)
+
+	workers := min(len(ranges), runtime.GOMAXPROCS(0))
+	var next, matched atomic.Int64
+	var stopped atomic.Bool
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			visit, done := newWorker()
+			defer done()
+			var vObj V = q.root.conf.NewV()
+			for !stopped.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= len(ranges) {
+					return
+				}
+				if !q.scanRange(ctx, ranges[i], vObj, visit, &matched, &stopped) {
+					stopped.Store(true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// scanRange visits the matching entries of one range, it reports false when the query has
+// to stop.
+func (q *Query[K, VT, V, VList]) scanRange(
+	ctx context.Context,
+	r queryRange[K, VT, V, VList],
+	vObj V,
+	visit func(v V) bool,
+	matched *atomic.Int64,
+	stopped *atomic.Bool,
+) bool {
+rows:
+	for i := r.lo; i < r.hi; i++ {
+		if (i-r.lo)%queryCheckInterval == 0 && (ctx.Err() != nil || stopped.Load()) {
+			return false
+		}
+		if !r.view.Vlist.Children(vObj, i) {
+			continue
+		}
+		for _, pred := range q.where {
+			if !pred(vObj) {
+				continue rows
+			}
+		}
+		if q.limit > 0 && matched.Add(1) > int64(q.limit) {
+			return false
+		}
+		if !visit(vObj) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sn *FlatNode[K, VT, V, VList]) collectQueryRanges(prefix []K, version uint64, ranges *[]queryRange[K, VT, V, VList]) {
+	switch sn.nodeType {
+	case NodeNonLeaf:
+		if len(prefix) > sn.level {
+			if child, ok := sn.children[prefix[sn.level]]; ok {
+				child.collectQueryRanges(prefix, version, ranges)
+			}
+			return
+		}
+		for _, child := range sn.children {
+			child.collectQueryRanges(prefix, version, ranges)
+		}
+	case NodeLeaf:
+		view := sn.viewAt(version)
+		lo, hi := 0, view.length()
+		if len(prefix) > sn.level { // the prefix selects a single entry
+			index, ok := sn.lookup(view, prefix[sn.level], sn.conf.NewV())
+			if !ok {
+				return
+			}
+			lo, hi = index, index+1
+		}
+		if lo < hi {
+			*ranges = append(*ranges, queryRange[K, VT, V, VList]{view: view, lo: lo, hi: hi})
+		}
+	}
+}
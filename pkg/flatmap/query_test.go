@@ -0,0 +1,86 @@
+package flatmap_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestQuery(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := uint64(1); id <= 1000; id++ {
+		deltas = append(deltas, bookDelta(2, id, id, 0))
+	}
+	node.FeedDeltaBulk(deltas)
+	ctx := context.Background()
+
+	even := func(b *books.Book) bool { return b.PageCount()%2 == 0 }
+	for _, tc := range []struct {
+		name  string
+		query *flatmap.Query[int, *books.BookT, *books.Book, *books.BookList]
+		want  int
+	}{
+		{"all", node.Query(nil), 1000},
+		{"where", node.Query(nil).Where(even), 500},
+		{"where twice", node.Query(nil).Where(even).Where(func(b *books.Book) bool { return b.PageCount() > 900 }), 50},
+		{"prefix", node.Query([]int{3}), 100},
+		{"single entry", node.Query([]int{3, 13}), 1},
+		{"missing entry", node.Query([]int{3, 14}), 0},
+		{"limit", node.Query(nil).Limit(7), 7},
+	} {
+		count, err := tc.query.Count(ctx)
+		if err != nil || count != tc.want {
+			t.Fatalf("%s: Count = %d, %v, want %d", tc.name, count, err, tc.want)
+		}
+	}
+
+	sum, err := flatmap.Aggregate(ctx, node.Query(nil), flatmap.Reducer[uint64, *books.Book]{
+		Init:   func() uint64 { return 0 },
+		Reduce: func(acc uint64, b *books.Book) uint64 { return acc + b.PageCount() },
+		Merge:  func(a, b uint64) uint64 { return a + b },
+	})
+	if err != nil || sum != 500500 {
+		t.Fatalf("Aggregate = %d, %v, want 500500", sum, err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := node.Query(nil).Count(canceled); err == nil {
+		t.Fatal("Count ignored a canceled context")
+	}
+}
+
+func TestQueryIsPinned(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	var deltas []flatmap.DeltaItem[int]
+	for id := uint64(1); id <= 100; id++ {
+		deltas = append(deltas, bookDelta(1, id, 1, 0))
+	}
+	node.FeedDeltaBulk(deltas)
+
+	rebuilt := false
+	var sum uint64
+	err := node.Query(nil).Each(context.Background(), func(b *books.Book) bool {
+		if !rebuilt { // a single shard is scanned by a single worker
+			rebuilt = true
+			for i := uint64(2); i <= 4; i++ { // recycles every unpinned buffer
+				for j := range deltas {
+					deltas[j] = bookDelta(1, uint64(j+1), i, 0)
+				}
+				node.FeedDeltaBulk(deltas)
+			}
+		}
+		sum += b.PageCount()
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 100 {
+		t.Fatalf("the scan summed %d pages, want 100 from the version it started on", sum)
+	}
+	expectPageCount(t, node, []int{1}, 4)
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package flatmap_test
+
+// raceEnabled skips allocation checks: sync.Pool drops items at random under the race detector.
+const raceEnabled = true
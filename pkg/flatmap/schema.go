@@ -0,0 +1,455 @@
+package flatmap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// BaseType is the type of a schema field, numbered as in the flatbuffers reflection schema.
+type BaseType byte
+
+const (
+	BaseTypeNone BaseType = iota
+	BaseTypeUType
+	BaseTypeBool
+	BaseTypeByte
+	BaseTypeUByte
+	BaseTypeShort
+	BaseTypeUShort
+	BaseTypeInt
+	BaseTypeUInt
+	BaseTypeLong
+	BaseTypeULong
+	BaseTypeFloat
+	BaseTypeDouble
+	BaseTypeString
+	BaseTypeVector
+	BaseTypeObj // tables and structs
+	BaseTypeUnion
+	BaseTypeArray
+)
+
+// IsScalar reports whether values of the type are stored inline.
+func (b BaseType) IsScalar() bool {
+	return b >= BaseTypeUType && b <= BaseTypeDouble
+}
+
+// Size returns the inline size of the type in bytes, offsets for the non scalar ones.
+func (b BaseType) Size() int {
+	switch b {
+	case BaseTypeUType, BaseTypeBool, BaseTypeByte, BaseTypeUByte:
+		return 1
+	case BaseTypeShort, BaseTypeUShort:
+		return 2
+	case BaseTypeLong, BaseTypeULong, BaseTypeDouble:
+		return 8
+	}
+	return 4
+}
+
+// Schema is a flatbuffers schema loaded with LoadSchema, ParseSchema or ParseBinarySchema.
+// It reads the fields of tables by name, without generated accessors.
+type Schema struct {
+	Namespace string
+	Objects   []*SchemaObject
+	Enums     []*SchemaEnum
+	RootTable *SchemaObject
+
+	objects map[string]*SchemaObject
+	enums   map[string]*SchemaEnum
+}
+
+// SchemaObject is a table or a struct of a schema.
+type SchemaObject struct {
+	Name     string // fully qualified
+	Fields   []*SchemaField
+	IsStruct bool
+	MinAlign int
+	ByteSize int // structs only
+
+	fields map[string]*SchemaField
+}
+
+// SchemaField is a field of a table or a struct.
+type SchemaField struct {
+	Name           string
+	Type           SchemaType
+	ID             int
+	Offset         int // into the vtable for tables, into the struct for structs
+	DefaultInteger int64
+	DefaultReal    float64
+	Deprecated     bool
+	Required       bool
+	Key            bool
+	Attributes     map[string]string // all the attributes of the field, including user declared ones
+}
+
+// SchemaType describes the values of a field. Element is the type of the elements of vectors
+// and arrays, Object and Enum are set for the fields referring to them.
+type SchemaType struct {
+	Base        BaseType
+	Element     BaseType
+	Object      *SchemaObject
+	Enum        *SchemaEnum
+	FixedLength int // arrays only
+}
+
+// SchemaEnum is an enum or a union of a schema.
+type SchemaEnum struct {
+	Name       string // fully qualified
+	Values     []SchemaEnumVal
+	IsUnion    bool
+	Underlying BaseType
+}
+
+// SchemaEnumVal is a value of an enum, Object is the member type of union values.
+type SchemaEnumVal struct {
+	Name   string
+	Value  int64
+	Object *SchemaObject
+}
+
+// EnumValue is the value of an enum field read from a table.
+type EnumValue struct {
+	Name  string // empty when the value is not declared
+	Value int64
+}
+
+func (e EnumValue) String() string {
+	if e.Name == "" {
+		return fmt.Sprint(e.Value)
+	}
+	return e.Name
+}
+
+// ObjectValue is a table or a struct read from a table, read its fields with Read.
+type ObjectValue struct {
+	Object *SchemaObject
+	Table  flatbuffers.Table
+}
+
+// Read reads a field of the object, see SchemaObject.Read.
+func (o ObjectValue) Read(field string) (any, error) {
+	return o.Object.Read(o.Table, field)
+}
+
+// LoadSchema loads a .fbs schema with its includes, or a binary .bfbs reflection schema.
+func LoadSchema(path string) (*Schema, error) {
+	if strings.EqualFold(filepath.Ext(path), ".bfbs") {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseBinarySchema(buf)
+	}
+	p := newSchemaParser()
+	if err := p.parseFile(path); err != nil {
+		return nil, err
+	}
+	return p.finish()
+}
+
+// Object returns the table or struct with the given name, qualified or not.
+func (s *Schema) Object(name string) *SchemaObject {
+	if o, ok := s.objects[name]; ok {
+		return o
+	}
+	return s.objects[s.qualify(name)]
+}
+
+// Enum returns the enum or union with the given name, qualified or not.
+func (s *Schema) Enum(name string) *SchemaEnum {
+	if e, ok := s.enums[name]; ok {
+		return e
+	}
+	return s.enums[s.qualify(name)]
+}
+
+func (s *Schema) qualify(name string) string {
+	if s.Namespace == "" {
+		return name
+	}
+	return s.Namespace + "." + name
+}
+
+// index builds the lookup maps once the schema is complete.
+func (s *Schema) index() {
+	s.objects = make(map[string]*SchemaObject, len(s.Objects))
+	for _, o := range s.Objects {
+		s.objects[o.Name] = o
+		o.fields = make(map[string]*SchemaField, len(o.Fields))
+		for _, f := range o.Fields {
+			o.fields[f.Name] = f
+		}
+	}
+	s.enums = make(map[string]*SchemaEnum, len(s.Enums))
+	for _, e := range s.Enums {
+		s.enums[e.Name] = e
+	}
+}
+
+// Field returns the field with the given name, nil if there is none.
+func (o *SchemaObject) Field(name string) *SchemaField {
+	return o.fields[name]
+}
+
+// ShortName returns the name of the object without its namespace.
+func (o *SchemaObject) ShortName() string {
+	return o.Name[strings.LastIndexByte(o.Name, '.')+1:]
+}
+
+// Has reports whether the field is present in the table. Struct fields are always present.
+func (o *SchemaObject) Has(tab flatbuffers.Table, field string) bool {
+	f := o.Field(field)
+	if f == nil {
+		return false
+	}
+	return o.IsStruct || tab.Offset(flatbuffers.VOffsetT(f.Offset)) != 0
+}
+
+// Read reads a field of a table, e.g. the one returned by V.Table(), or of a struct.
+// Scalars are returned with their Go type (uint64 for ulong, float64 for double, ...), absent
+// fields holding their default. Strings are returned as string, enums as EnumValue, tables,
+// structs and union members as ObjectValue and vectors as []any; absent non scalar fields
+// are nil.
+func (o *SchemaObject) Read(tab flatbuffers.Table, field string) (any, error) {
+	f := o.Field(field)
+	if f == nil {
+		return nil, fmt.Errorf("%s has no field %q", o.Name, field)
+	}
+	return o.ReadField(tab, f)
+}
+
+// Project reads the given fields of a table, all of them when none is given. Absent non
+// scalar fields are left out.
+func (o *SchemaObject) Project(tab flatbuffers.Table, fields ...string) (map[string]any, error) {
+	if len(fields) == 0 {
+		for _, f := range o.Fields {
+			if !f.Deprecated && f.Type.Base != BaseTypeUType {
+				fields = append(fields, f.Name)
+			}
+		}
+	}
+	values := make(map[string]any, len(fields))
+	for _, name := range fields {
+		value, err := o.Read(tab, name)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// ReadField reads a field of the object, see Read.
+func (o *SchemaObject) ReadField(tab flatbuffers.Table, f *SchemaField) (any, error) {
+	if o.IsStruct {
+		return readInline(tab, tab.Pos+flatbuffers.UOffsetT(f.Offset), f.Type, f.Type.Base)
+	}
+	off := flatbuffers.UOffsetT(tab.Offset(flatbuffers.VOffsetT(f.Offset)))
+	if off == 0 {
+		return fieldDefault(f), nil
+	}
+	pos := tab.Pos + off
+	switch f.Type.Base {
+	case BaseTypeString:
+		return string(tab.ByteVector(pos)), nil
+	case BaseTypeVector:
+		return readVector(tab, off, f.Type)
+	case BaseTypeObj:
+		if f.Type.Object.IsStruct {
+			return ObjectValue{Object: f.Type.Object, Table: flatbuffers.Table{Bytes: tab.Bytes, Pos: pos}}, nil
+		}
+		return ObjectValue{Object: f.Type.Object, Table: flatbuffers.Table{Bytes: tab.Bytes, Pos: tab.Indirect(pos)}}, nil
+	case BaseTypeUnion:
+		return o.readUnion(tab, f, pos)
+	}
+	return readInline(tab, pos, f.Type, f.Type.Base)
+}
+
+// readUnion resolves the member of a union field with its companion _type field.
+func (o *SchemaObject) readUnion(tab flatbuffers.Table, f *SchemaField, pos flatbuffers.UOffsetT) (any, error) {
+	typeField := o.Field(f.Name + "_type")
+	if typeField == nil {
+		return nil, fmt.Errorf("%s has no field %q", o.Name, f.Name+"_type")
+	}
+	kind, err := o.ReadField(tab, typeField)
+	if err != nil {
+		return nil, err
+	}
+	value := kind.(EnumValue).Value
+	for _, member := range f.Type.Enum.Values {
+		if member.Value == value && member.Object != nil {
+			return ObjectValue{Object: member.Object, Table: flatbuffers.Table{Bytes: tab.Bytes, Pos: tab.Indirect(pos)}}, nil
+		}
+	}
+	return nil, nil
+}
+
+func readVector(tab flatbuffers.Table, off flatbuffers.UOffsetT, t SchemaType) ([]any, error) {
+	start := tab.Vector(off)
+	n := tab.VectorLen(off)
+	size := flatbuffers.UOffsetT(t.Element.Size())
+	if t.Element == BaseTypeObj && t.Object.IsStruct {
+		size = flatbuffers.UOffsetT(t.Object.ByteSize)
+	}
+	values := make([]any, n)
+	for i := range values {
+		pos := start + flatbuffers.UOffsetT(i)*size
+		switch t.Element {
+		case BaseTypeString:
+			values[i] = string(tab.ByteVector(pos))
+		case BaseTypeObj:
+			if t.Object.IsStruct {
+				values[i] = ObjectValue{Object: t.Object, Table: flatbuffers.Table{Bytes: tab.Bytes, Pos: pos}}
+			} else {
+				values[i] = ObjectValue{Object: t.Object, Table: flatbuffers.Table{Bytes: tab.Bytes, Pos: tab.Indirect(pos)}}
+			}
+		default:
+			value, err := readInline(tab, pos, t, t.Element)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+// readInline reads a value stored at pos: a scalar, an enum, a struct or a fixed array.
+func readInline(tab flatbuffers.Table, pos flatbuffers.UOffsetT, t SchemaType, base BaseType) (any, error) {
+	var value any
+	switch base {
+	case BaseTypeBool:
+		value = tab.GetBool(pos)
+	case BaseTypeByte:
+		value = tab.GetInt8(pos)
+	case BaseTypeUType, BaseTypeUByte:
+		value = tab.GetUint8(pos)
+	case BaseTypeShort:
+		value = tab.GetInt16(pos)
+	case BaseTypeUShort:
+		value = tab.GetUint16(pos)
+	case BaseTypeInt:
+		value = tab.GetInt32(pos)
+	case BaseTypeUInt:
+		value = tab.GetUint32(pos)
+	case BaseTypeLong:
+		value = tab.GetInt64(pos)
+	case BaseTypeULong:
+		value = tab.GetUint64(pos)
+	case BaseTypeFloat:
+		value = tab.GetFloat32(pos)
+	case BaseTypeDouble:
+		value = tab.GetFloat64(pos)
+	case BaseTypeObj:
+		return ObjectValue{Object: t.Object, Table: flatbuffers.Table{Bytes: tab.Bytes, Pos: pos}}, nil
+	case BaseTypeArray:
+		size := flatbuffers.UOffsetT(t.Element.Size())
+		if t.Element == BaseTypeObj {
+			size = flatbuffers.UOffsetT(t.Object.ByteSize)
+		}
+		values := make([]any, t.FixedLength)
+		for i := range values {
+			element, err := readInline(tab, pos+flatbuffers.UOffsetT(i)*size, t, t.Element)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = element
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("cannot read a %d value inline", base)
+	}
+	if t.Enum != nil {
+		return t.Enum.value(scalarInt64(value)), nil
+	}
+	return value, nil
+}
+
+// fieldDefault returns the value of an absent field.
+func fieldDefault(f *SchemaField) any {
+	var value any
+	switch f.Type.Base {
+	case BaseTypeBool:
+		value = f.DefaultInteger != 0
+	case BaseTypeByte:
+		value = int8(f.DefaultInteger)
+	case BaseTypeUType, BaseTypeUByte:
+		value = uint8(f.DefaultInteger)
+	case BaseTypeShort:
+		value = int16(f.DefaultInteger)
+	case BaseTypeUShort:
+		value = uint16(f.DefaultInteger)
+	case BaseTypeInt:
+		value = int32(f.DefaultInteger)
+	case BaseTypeUInt:
+		value = uint32(f.DefaultInteger)
+	case BaseTypeLong:
+		value = f.DefaultInteger
+	case BaseTypeULong:
+		value = uint64(f.DefaultInteger)
+	case BaseTypeFloat:
+		value = float32(f.DefaultReal)
+	case BaseTypeDouble:
+		value = f.DefaultReal
+	default:
+		return nil
+	}
+	if f.Type.Enum != nil {
+		return f.Type.Enum.value(f.DefaultInteger)
+	}
+	return value
+}
+
+func (e *SchemaEnum) value(v int64) EnumValue {
+	for _, ev := range e.Values {
+		if ev.Value == v {
+			return EnumValue{Name: ev.Name, Value: v}
+		}
+	}
+	return EnumValue{Value: v}
+}
+
+// valueOf returns the value of the enum named name.
+func (e *SchemaEnum) valueOf(name string) (int64, error) {
+	for _, ev := range e.Values {
+		if ev.Name == name {
+			return ev.Value, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a value of %s", name, e.Name)
+}
+
+func scalarInt64(value any) int64 {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case int8:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	}
+	return 0
+}
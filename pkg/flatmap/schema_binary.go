@@ -0,0 +1,211 @@
+package flatmap
+
+import (
+	"fmt"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Vtable offsets of the reflection.fbs tables read by ParseBinarySchema.
+const (
+	bfbsSchemaObjects   = 4
+	bfbsSchemaEnums     = 6
+	bfbsSchemaRootTable = 12
+
+	bfbsObjectName     = 4
+	bfbsObjectFields   = 6
+	bfbsObjectIsStruct = 8
+	bfbsObjectMinAlign = 10
+	bfbsObjectByteSize = 12
+
+	bfbsFieldName           = 4
+	bfbsFieldType           = 6
+	bfbsFieldID             = 8
+	bfbsFieldOffset         = 10
+	bfbsFieldDefaultInteger = 12
+	bfbsFieldDefaultReal    = 14
+	bfbsFieldDeprecated     = 16
+	bfbsFieldRequired       = 18
+	bfbsFieldKey            = 20
+	bfbsFieldAttributes     = 22
+
+	bfbsTypeBaseType    = 4
+	bfbsTypeElement     = 6
+	bfbsTypeIndex       = 8
+	bfbsTypeFixedLength = 10
+
+	bfbsEnumName       = 4
+	bfbsEnumValues     = 6
+	bfbsEnumIsUnion    = 8
+	bfbsEnumUnderlying = 10
+
+	bfbsEnumValName      = 4
+	bfbsEnumValValue     = 6
+	bfbsEnumValUnionType = 10
+
+	bfbsKeyValueKey   = 4
+	bfbsKeyValueValue = 6
+)
+
+// ParseBinarySchema parses a binary reflection schema, as written by flatc --binary --schema.
+func ParseBinarySchema(buf []byte) (s *Schema, err error) {
+	if len(buf) < 8 || !flatbuffers.BufferHasIdentifier(buf, "BFBS") {
+		return nil, fmt.Errorf("not a binary flatbuffers schema")
+	}
+	defer func() { // a truncated buffer makes the table accessors panic
+		if r := recover(); r != nil {
+			s, err = nil, fmt.Errorf("invalid binary flatbuffers schema: %v", r)
+		}
+	}()
+	root := flatbuffers.Table{Bytes: buf, Pos: flatbuffers.GetUOffsetT(buf)}
+	s = &Schema{}
+
+	objects := tableVector(root, bfbsSchemaObjects)
+	for range objects {
+		s.Objects = append(s.Objects, &SchemaObject{})
+	}
+	enums := tableVector(root, bfbsSchemaEnums)
+	for range enums {
+		s.Enums = append(s.Enums, &SchemaEnum{})
+	}
+	// types refer to objects and enums by index, fill them once all exist
+	for i, tab := range objects {
+		o := s.Objects[i]
+		o.Name = tableString(tab, bfbsObjectName)
+		o.IsStruct = tab.GetBoolSlot(bfbsObjectIsStruct, false)
+		o.MinAlign = int(tab.GetInt32Slot(bfbsObjectMinAlign, 0))
+		o.ByteSize = int(tab.GetInt32Slot(bfbsObjectByteSize, 0))
+		for _, ft := range tableVector(tab, bfbsObjectFields) {
+			t, err := s.binaryType(ft, bfbsFieldType)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", o.Name, err)
+			}
+			o.Fields = append(o.Fields, &SchemaField{
+				Name:           tableString(ft, bfbsFieldName),
+				Type:           t,
+				ID:             int(ft.GetUint16Slot(bfbsFieldID, 0)),
+				Offset:         int(ft.GetUint16Slot(bfbsFieldOffset, 0)),
+				DefaultInteger: ft.GetInt64Slot(bfbsFieldDefaultInteger, 0),
+				DefaultReal:    ft.GetFloat64Slot(bfbsFieldDefaultReal, 0),
+				Deprecated:     ft.GetBoolSlot(bfbsFieldDeprecated, false),
+				Required:       ft.GetBoolSlot(bfbsFieldRequired, false),
+				Key:            ft.GetBoolSlot(bfbsFieldKey, false),
+				Attributes:     tableAttributes(ft, bfbsFieldAttributes),
+			})
+		}
+		// fields are sorted by name in the reflection schema, use the schema order
+		sortFields(o)
+	}
+	for i, tab := range enums {
+		e := s.Enums[i]
+		e.Name = tableString(tab, bfbsEnumName)
+		e.IsUnion = tab.GetBoolSlot(bfbsEnumIsUnion, false)
+		underlying, err := s.binaryType(tab, bfbsEnumUnderlying)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		e.Underlying = underlying.Base
+		for _, vt := range tableVector(tab, bfbsEnumValues) {
+			ev := SchemaEnumVal{Name: tableString(vt, bfbsEnumValName), Value: vt.GetInt64Slot(bfbsEnumValValue, 0)}
+			if e.IsUnion {
+				member, err := s.binaryType(vt, bfbsEnumValUnionType)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", e.Name, err)
+				}
+				ev.Object = member.Object
+			}
+			e.Values = append(e.Values, ev)
+		}
+	}
+	if o := root.Offset(bfbsSchemaRootTable); o != 0 {
+		rootPos := root.Indirect(root.Pos + flatbuffers.UOffsetT(o))
+		name := tableString(flatbuffers.Table{Bytes: buf, Pos: rootPos}, bfbsObjectName)
+		for _, obj := range s.Objects {
+			if obj.Name == name {
+				s.RootTable = obj
+			}
+		}
+		if s.RootTable != nil {
+			s.Namespace = parentNamespace(s.RootTable.Name)
+		}
+	}
+	s.index()
+	return s, nil
+}
+
+// binaryType reads the reflection Type table stored in a slot of tab.
+func (s *Schema) binaryType(tab flatbuffers.Table, slot flatbuffers.VOffsetT) (SchemaType, error) {
+	var t SchemaType
+	o := tab.Offset(slot)
+	if o == 0 {
+		return t, nil
+	}
+	tt := flatbuffers.Table{Bytes: tab.Bytes, Pos: tab.Indirect(tab.Pos + flatbuffers.UOffsetT(o))}
+	t.Base = BaseType(tt.GetInt8Slot(bfbsTypeBaseType, 0))
+	t.Element = BaseType(tt.GetInt8Slot(bfbsTypeElement, 0))
+	t.FixedLength = int(tt.GetUint16Slot(bfbsTypeFixedLength, 0))
+	index := int(tt.GetInt32Slot(bfbsTypeIndex, -1))
+	if index < 0 {
+		return t, nil
+	}
+	if t.Base == BaseTypeObj || t.Element == BaseTypeObj {
+		if index >= len(s.Objects) {
+			return t, fmt.Errorf("object index %d out of range", index)
+		}
+		t.Object = s.Objects[index]
+	} else {
+		if index >= len(s.Enums) {
+			return t, fmt.Errorf("enum index %d out of range", index)
+		}
+		t.Enum = s.Enums[index]
+	}
+	return t, nil
+}
+
+func sortFields(o *SchemaObject) {
+	fields := o.Fields
+	for i := 1; i < len(fields); i++ {
+		for j := i; j > 0 && fieldBefore(fields[j], fields[j-1], o.IsStruct); j-- {
+			fields[j], fields[j-1] = fields[j-1], fields[j]
+		}
+	}
+}
+
+func fieldBefore(a, b *SchemaField, isStruct bool) bool {
+	if isStruct {
+		return a.Offset < b.Offset
+	}
+	return a.ID < b.ID
+}
+
+// tableVector returns the tables of the vector stored in a slot of tab.
+func tableVector(tab flatbuffers.Table, slot flatbuffers.VOffsetT) []flatbuffers.Table {
+	o := flatbuffers.UOffsetT(tab.Offset(slot))
+	if o == 0 {
+		return nil
+	}
+	start := tab.Vector(o)
+	tables := make([]flatbuffers.Table, tab.VectorLen(o))
+	for i := range tables {
+		pos := start + flatbuffers.UOffsetT(i)*flatbuffers.SizeUOffsetT
+		tables[i] = flatbuffers.Table{Bytes: tab.Bytes, Pos: tab.Indirect(pos)}
+	}
+	return tables
+}
+
+func tableString(tab flatbuffers.Table, slot flatbuffers.VOffsetT) string {
+	o := flatbuffers.UOffsetT(tab.Offset(slot))
+	if o == 0 {
+		return ""
+	}
+	return string(tab.ByteVector(tab.Pos + o))
+}
+
+// tableAttributes returns the KeyValue vector stored in a slot of tab as a map.
+func tableAttributes(tab flatbuffers.Table, slot flatbuffers.VOffsetT) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range tableVector(tab, slot) {
+		attrs[tableString(kv, bfbsKeyValueKey)] = tableString(kv, bfbsKeyValueValue)
+	}
+	return attrs
+}
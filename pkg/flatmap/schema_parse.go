@@ -0,0 +1,821 @@
+package flatmap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseSchema parses the text of a .fbs schema. Includes are resolved relative to the
+// working directory, use LoadSchema to resolve them relative to the schema file.
+func ParseSchema(src string) (*Schema, error) {
+	p := newSchemaParser()
+	if err := p.parse(src, "."); err != nil {
+		return nil, err
+	}
+	return p.finish()
+}
+
+var scalarTypes = map[string]BaseType{
+	"bool":    BaseTypeBool,
+	"byte":    BaseTypeByte,
+	"int8":    BaseTypeByte,
+	"ubyte":   BaseTypeUByte,
+	"uint8":   BaseTypeUByte,
+	"short":   BaseTypeShort,
+	"int16":   BaseTypeShort,
+	"ushort":  BaseTypeUShort,
+	"uint16":  BaseTypeUShort,
+	"int":     BaseTypeInt,
+	"int32":   BaseTypeInt,
+	"uint":    BaseTypeUInt,
+	"uint32":  BaseTypeUInt,
+	"long":    BaseTypeLong,
+	"int64":   BaseTypeLong,
+	"ulong":   BaseTypeULong,
+	"uint64":  BaseTypeULong,
+	"float":   BaseTypeFloat,
+	"float32": BaseTypeFloat,
+	"double":  BaseTypeDouble,
+	"float64": BaseTypeDouble,
+	"string":  BaseTypeString,
+}
+
+// parsedField is a field as written in the schema, resolved by finish.
+type parsedField struct {
+	field        *SchemaField
+	typeName     string
+	vector       bool
+	fixedLength  int
+	defaultValue string
+	hasID        bool
+}
+
+type parsedObject struct {
+	object     *SchemaObject
+	namespace  string
+	fields     []*parsedField
+	forceAlign int
+}
+
+type parsedEnum struct {
+	enum       *SchemaEnum
+	namespace  string
+	underlying string
+	members    []string // union member types
+}
+
+type schemaParser struct {
+	schema   *Schema
+	objects  []*parsedObject
+	enums    []*parsedEnum
+	rootType string
+	rootNS   string
+	included map[string]bool
+
+	// lexer state of the file being parsed
+	src       string
+	pos       int
+	namespace string
+}
+
+func newSchemaParser() *schemaParser {
+	return &schemaParser{schema: &Schema{}, included: make(map[string]bool)}
+}
+
+func (p *schemaParser) parseFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if p.included[abs] {
+		return nil
+	}
+	p.included[abs] = true
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := p.parse(string(src), filepath.Dir(path)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (p *schemaParser) parse(src string, dir string) error {
+	// includes are parsed in the middle of the including file
+	savedSrc, savedPos, savedNamespace := p.src, p.pos, p.namespace
+	p.src, p.pos, p.namespace = src, 0, ""
+	defer func() {
+		p.src, p.pos, p.namespace = savedSrc, savedPos, savedNamespace
+	}()
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "":
+			return nil
+		case "include":
+			file, err := p.expectString()
+			if err != nil {
+				return err
+			}
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+			if err := p.parseFile(filepath.Join(dir, file)); err != nil {
+				return err
+			}
+		case "namespace":
+			ns, err := p.ident()
+			if err != nil {
+				return err
+			}
+			p.namespace = ns
+			p.schema.Namespace = ns
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "attribute", "file_identifier", "file_extension":
+			if _, err := p.next(); err != nil {
+				return err
+			}
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "root_type":
+			name, err := p.ident()
+			if err != nil {
+				return err
+			}
+			p.rootType, p.rootNS = name, p.namespace
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "table", "struct":
+			if err := p.parseObject(tok == "struct"); err != nil {
+				return err
+			}
+		case "enum", "union":
+			if err := p.parseEnum(tok == "union"); err != nil {
+				return err
+			}
+		case "rpc_service":
+			if err := p.skipBlock(); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("unexpected %q", tok)
+		}
+	}
+}
+
+func (p *schemaParser) parseObject(isStruct bool) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	attrs, err := p.attributes()
+	if err != nil {
+		return err
+	}
+	po := &parsedObject{
+		object:    &SchemaObject{Name: qualifiedName(p.namespace, name), IsStruct: isStruct},
+		namespace: p.namespace,
+	}
+	if align, ok := attrs["force_align"]; ok {
+		po.forceAlign, _ = strconv.Atoi(align)
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if tok == "}" {
+			break
+		}
+		pf := &parsedField{field: &SchemaField{Name: tok}}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		if err := p.fieldType(pf); err != nil {
+			return err
+		}
+		tok, err = p.next()
+		if err != nil {
+			return err
+		}
+		if tok == "=" {
+			if pf.defaultValue, err = p.next(); err != nil {
+				return err
+			}
+			if tok, err = p.next(); err != nil {
+				return err
+			}
+		}
+		if tok == "(" {
+			p.pos-- // let attributes read the parenthesis
+			attrs, err := p.attributes()
+			if err != nil {
+				return err
+			}
+			if id, ok := attrs["id"]; ok {
+				pf.field.ID, err = strconv.Atoi(id)
+				if err != nil {
+					return p.errorf("invalid id %q", id)
+				}
+				pf.hasID = true
+			}
+			_, pf.field.Deprecated = attrs["deprecated"]
+			_, pf.field.Required = attrs["required"]
+			_, pf.field.Key = attrs["key"]
+			pf.field.Attributes = attrs
+			if tok, err = p.next(); err != nil {
+				return err
+			}
+		}
+		if tok != ";" {
+			return p.errorf("expected ; after field %s, got %q", pf.field.Name, tok)
+		}
+		po.fields = append(po.fields, pf)
+	}
+	p.objects = append(p.objects, po)
+	return nil
+}
+
+func (p *schemaParser) fieldType(pf *parsedField) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok != "[" {
+		pf.typeName = tok
+		return nil
+	}
+	if pf.typeName, err = p.ident(); err != nil {
+		return err
+	}
+	tok, err = p.next()
+	if err != nil {
+		return err
+	}
+	if tok == ":" {
+		n, err := p.next()
+		if err != nil {
+			return err
+		}
+		if pf.fixedLength, err = strconv.Atoi(n); err != nil || pf.fixedLength <= 0 {
+			return p.errorf("invalid array length %q", n)
+		}
+		tok, err = p.next()
+		if err != nil {
+			return err
+		}
+	} else {
+		pf.vector = true
+	}
+	if tok != "]" {
+		return p.errorf("expected ], got %q", tok)
+	}
+	return nil
+}
+
+func (p *schemaParser) parseEnum(isUnion bool) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	pe := &parsedEnum{
+		enum:      &SchemaEnum{Name: qualifiedName(p.namespace, name), IsUnion: isUnion},
+		namespace: p.namespace,
+	}
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok == ":" {
+		if pe.underlying, err = p.ident(); err != nil {
+			return err
+		}
+		if tok, err = p.next(); err != nil {
+			return err
+		}
+	} else if !isUnion {
+		return p.errorf("enum %s needs an underlying type", name)
+	}
+	var attrs map[string]string
+	if tok == "(" {
+		p.pos--
+		if attrs, err = p.attributes(); err != nil {
+			return err
+		}
+		if tok, err = p.next(); err != nil {
+			return err
+		}
+	}
+	if tok != "{" {
+		return p.errorf("expected {, got %q", tok)
+	}
+	_, bitFlags := attrs["bit_flags"]
+	next := int64(0)
+	if isUnion {
+		pe.enum.Values = append(pe.enum.Values, SchemaEnumVal{Name: "NONE"})
+		pe.members = append(pe.members, "")
+		next = 1
+	}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if tok == "}" {
+			break
+		}
+		if tok == "," {
+			continue
+		}
+		valueName, member := tok, tok
+		if tok, err = p.next(); err != nil {
+			return err
+		}
+		if isUnion && tok == ":" { // Alias: Type
+			if member, err = p.ident(); err != nil {
+				return err
+			}
+			if tok, err = p.next(); err != nil {
+				return err
+			}
+		}
+		if tok == "=" {
+			literal, err := p.next()
+			if err != nil {
+				return err
+			}
+			if next, err = strconv.ParseInt(literal, 0, 64); err != nil {
+				return p.errorf("invalid enum value %q", literal)
+			}
+			if tok, err = p.next(); err != nil {
+				return err
+			}
+		}
+		value := next
+		if bitFlags {
+			value = 1 << next
+		}
+		pe.enum.Values = append(pe.enum.Values, SchemaEnumVal{Name: valueName[strings.LastIndexByte(valueName, '.')+1:], Value: value})
+		pe.members = append(pe.members, member)
+		next++
+		if tok == "}" {
+			break
+		}
+		if tok != "," {
+			return p.errorf("expected , in enum %s, got %q", name, tok)
+		}
+	}
+	p.enums = append(p.enums, pe)
+	return nil
+}
+
+// finish resolves the types, lays out the structs and returns the schema.
+func (p *schemaParser) finish() (*Schema, error) {
+	s := p.schema
+	for _, pe := range p.enums {
+		s.Enums = append(s.Enums, pe.enum)
+	}
+	for _, po := range p.objects {
+		s.Objects = append(s.Objects, po.object)
+	}
+	s.index()
+	for _, pe := range p.enums {
+		if pe.enum.IsUnion {
+			pe.enum.Underlying = BaseTypeUType
+			for i, member := range pe.members {
+				if member == "" {
+					continue
+				}
+				if pe.enum.Values[i].Object = p.lookupObject(member, pe.namespace); pe.enum.Values[i].Object == nil {
+					return nil, fmt.Errorf("union %s: unknown table %s", pe.enum.Name, member)
+				}
+			}
+			continue
+		}
+		base, ok := scalarTypes[pe.underlying]
+		if !ok || !base.IsScalar() {
+			return nil, fmt.Errorf("enum %s: invalid underlying type %s", pe.enum.Name, pe.underlying)
+		}
+		pe.enum.Underlying = base
+	}
+	for _, po := range p.objects {
+		if err := p.resolveFields(po); err != nil {
+			return nil, err
+		}
+	}
+	layouts := make(map[*SchemaObject]bool)
+	for _, po := range p.objects {
+		if po.object.IsStruct {
+			if err := p.layoutStruct(po, layouts, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.rootType != "" {
+		if s.RootTable = p.lookupObject(p.rootType, p.rootNS); s.RootTable == nil {
+			return nil, fmt.Errorf("unknown root_type %s", p.rootType)
+		}
+	}
+	s.index() // fields were added
+	return s, nil
+}
+
+func (p *schemaParser) resolveFields(po *parsedObject) error {
+	o := po.object
+	nextID := 0
+	for _, pf := range po.fields {
+		f := pf.field
+		t, err := p.resolveType(pf, po.namespace)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", o.Name, f.Name, err)
+		}
+		f.Type = t
+		if err := p.resolveDefault(pf); err != nil {
+			return fmt.Errorf("%s.%s: %w", o.Name, f.Name, err)
+		}
+		if t.Base == BaseTypeUnion { // the value is preceded by its type
+			typeField := &SchemaField{
+				Name: f.Name + "_type",
+				Type: SchemaType{Base: BaseTypeUType, Enum: t.Enum},
+			}
+			if pf.hasID {
+				typeField.ID = f.ID - 1
+			} else {
+				typeField.ID = nextID
+				nextID++
+			}
+			o.Fields = append(o.Fields, typeField)
+		}
+		if !pf.hasID {
+			f.ID = nextID
+		}
+		nextID = f.ID + 1
+		o.Fields = append(o.Fields, f)
+	}
+	if o.IsStruct {
+		return nil
+	}
+	slices.SortStableFunc(o.Fields, func(a, b *SchemaField) int { return a.ID - b.ID })
+	for _, f := range o.Fields {
+		f.Offset = 4 + 2*f.ID
+	}
+	return nil
+}
+
+func (p *schemaParser) resolveType(pf *parsedField, namespace string) (SchemaType, error) {
+	var t SchemaType
+	element, err := p.resolveBase(pf.typeName, namespace, &t)
+	if err != nil {
+		return t, err
+	}
+	switch {
+	case pf.vector:
+		if element == BaseTypeUnion || element == BaseTypeVector {
+			return t, fmt.Errorf("unsupported vector of %s", pf.typeName)
+		}
+		t.Base, t.Element = BaseTypeVector, element
+	case pf.fixedLength > 0:
+		t.Base, t.Element, t.FixedLength = BaseTypeArray, element, pf.fixedLength
+	default:
+		t.Base = element
+	}
+	return t, nil
+}
+
+// resolveBase returns the base type of a type name, setting the enum or the object of t.
+func (p *schemaParser) resolveBase(name string, namespace string, t *SchemaType) (BaseType, error) {
+	if base, ok := scalarTypes[name]; ok {
+		return base, nil
+	}
+	if e := p.lookupEnum(name, namespace); e != nil {
+		t.Enum = e
+		if e.IsUnion {
+			return BaseTypeUnion, nil
+		}
+		return e.Underlying, nil
+	}
+	if o := p.lookupObject(name, namespace); o != nil {
+		t.Object = o
+		return BaseTypeObj, nil
+	}
+	return BaseTypeNone, fmt.Errorf("unknown type %s", name)
+}
+
+func (p *schemaParser) resolveDefault(pf *parsedField) error {
+	f := pf.field
+	literal := pf.defaultValue
+	if literal == "" {
+		return nil
+	}
+	if !f.Type.Base.IsScalar() {
+		if literal == "null" {
+			return nil
+		}
+		return fmt.Errorf("only scalars can have a default value")
+	}
+	if f.Type.Enum != nil {
+		for _, ev := range f.Type.Enum.Values {
+			if ev.Name == literal {
+				f.DefaultInteger = ev.Value
+				return nil
+			}
+		}
+	}
+	switch {
+	case literal == "true":
+		f.DefaultInteger = 1
+	case literal == "false":
+		f.DefaultInteger = 0
+	case f.Type.Base == BaseTypeFloat || f.Type.Base == BaseTypeDouble:
+		value, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return fmt.Errorf("invalid default %q", literal)
+		}
+		f.DefaultReal = value
+	default:
+		value, err := strconv.ParseInt(literal, 0, 64)
+		if err != nil {
+			unsigned, uerr := strconv.ParseUint(literal, 0, 64)
+			if uerr != nil {
+				return fmt.Errorf("invalid default %q", literal)
+			}
+			value = int64(unsigned)
+		}
+		f.DefaultInteger = value
+	}
+	return nil
+}
+
+// layoutStruct computes the field offsets, the size and the alignment of a struct, after
+// the ones of the structs it contains.
+func (p *schemaParser) layoutStruct(po *parsedObject, done map[*SchemaObject]bool, visiting []*SchemaObject) error {
+	o := po.object
+	if done[o] {
+		return nil
+	}
+	if slices.Contains(visiting, o) {
+		return fmt.Errorf("struct %s contains itself", o.Name)
+	}
+	visiting = append(visiting, o)
+	offset, align := 0, 1
+	for _, f := range o.Fields {
+		base := f.Type.Base
+		if base == BaseTypeArray {
+			base = f.Type.Element
+		}
+		var size, fieldAlign int
+		switch {
+		case base == BaseTypeObj && f.Type.Object.IsStruct:
+			for _, nested := range p.objects {
+				if nested.object == f.Type.Object {
+					if err := p.layoutStruct(nested, done, visiting); err != nil {
+						return err
+					}
+				}
+			}
+			size, fieldAlign = f.Type.Object.ByteSize, f.Type.Object.MinAlign
+		case base.IsScalar():
+			size, fieldAlign = base.Size(), base.Size()
+		default:
+			return fmt.Errorf("struct %s: field %s must be a scalar, a struct or an array", o.Name, f.Name)
+		}
+		if f.Type.Base == BaseTypeArray {
+			size *= f.Type.FixedLength
+		}
+		offset = alignUp(offset, fieldAlign)
+		f.Offset = offset
+		offset += size
+		align = max(align, fieldAlign)
+	}
+	o.MinAlign = max(align, po.forceAlign)
+	o.ByteSize = alignUp(offset, o.MinAlign)
+	done[o] = true
+	return nil
+}
+
+func alignUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// lookupObject resolves a type name from a namespace, searching the enclosing namespaces.
+func (p *schemaParser) lookupObject(name string, namespace string) *SchemaObject {
+	for ns := namespace; ; ns = parentNamespace(ns) {
+		if o, ok := p.schema.objects[qualifiedName(ns, name)]; ok {
+			return o
+		}
+		if ns == "" {
+			return nil
+		}
+	}
+}
+
+func (p *schemaParser) lookupEnum(name string, namespace string) *SchemaEnum {
+	for ns := namespace; ; ns = parentNamespace(ns) {
+		if e, ok := p.schema.enums[qualifiedName(ns, name)]; ok {
+			return e
+		}
+		if ns == "" {
+			return nil
+		}
+	}
+}
+
+func qualifiedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func parentNamespace(namespace string) string {
+	i := strings.LastIndexByte(namespace, '.')
+	if i < 0 {
+		return ""
+	}
+	return namespace[:i]
+}
+
+// attributes parses an optional (name, name: value, ...) list.
+func (p *schemaParser) attributes() (map[string]string, error) {
+	attrs := make(map[string]string)
+	save := p.pos
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok != "(" {
+		p.pos = save
+		return attrs, nil
+	}
+	for {
+		name, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if name == ")" {
+			return attrs, nil
+		}
+		if name == "," {
+			continue
+		}
+		attrs[strings.Trim(name, `"`)] = ""
+		save = p.pos
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == ":" {
+			value, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			attrs[strings.Trim(name, `"`)] = strings.Trim(value, `"`)
+		} else {
+			p.pos = save
+		}
+	}
+}
+
+func (p *schemaParser) skipBlock() error {
+	depth := 0
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "":
+			return p.errorf("unterminated block")
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+func (p *schemaParser) expect(want string) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return p.errorf("expected %q, got %q", want, tok)
+	}
+	return nil
+}
+
+func (p *schemaParser) expectString() (string, error) {
+	tok, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if len(tok) < 2 || tok[0] != '"' {
+		return "", p.errorf("expected a string, got %q", tok)
+	}
+	return tok[1 : len(tok)-1], nil
+}
+
+func (p *schemaParser) ident() (string, error) {
+	tok, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if tok == "" || !isIdentStart(rune(tok[0])) {
+		return "", p.errorf("expected a name, got %q", tok)
+	}
+	return tok, nil
+}
+
+// next returns the next token, "" at the end of the input. Tokens are names (dots
+// included), numbers, quoted strings and single punctuation characters.
+func (p *schemaParser) next() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", nil
+	}
+	start := p.pos
+	c := rune(p.src[p.pos])
+	switch {
+	case c == '"':
+		end := strings.IndexByte(p.src[p.pos+1:], '"')
+		if end < 0 {
+			return "", p.errorf("unterminated string")
+		}
+		p.pos += end + 2
+	case isIdentStart(c):
+		for p.pos < len(p.src) && (isIdentStart(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case unicode.IsDigit(c) || c == '-' || c == '+' || c == '.':
+		p.pos++
+		for p.pos < len(p.src) && isNumberChar(p.src[p.pos], p.src[p.pos-1]) {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *schemaParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch {
+		case unicode.IsSpace(rune(p.src[p.pos])):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 4
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *schemaParser) errorf(format string, args ...any) error {
+	line := strings.Count(p.src[:min(p.pos, len(p.src))], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || unicode.IsLetter(c)
+}
+
+func isNumberChar(c, prev byte) bool {
+	switch {
+	case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '.', c == 'x', c == 'X':
+		return true
+	case c == '-' || c == '+':
+		return prev == 'e' || prev == 'E'
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package flatmap_test
+
+import (
+	"slices"
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestLoadSchema(t *testing.T) {
+	schema, err := flatmap.LoadSchema("../../example/books.fbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := schema.Object("Book")
+	if book == nil || schema.RootTable == nil || schema.RootTable.Name != "books.BookList" || schema.Enum("Status") == nil {
+		t.Fatal("books.fbs is missing Book, Status or its root table")
+	}
+
+	data := packBook(&books.BookT{Id: 3, Title: "dune", Rate: 4.5, ListField: []string{"a", "b"}, ScalarListField: []uint64{1, 2}})
+	values, err := book.Project(books.GetRootAsBook(data, 0).Table())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["id"] != uint64(3) || values["title"] != "dune" || values["rate"] != 4.5 || values["page_count"] != uint64(0) {
+		t.Fatalf("Project = %v", values)
+	}
+	if list := values["list_field"].([]any); !slices.Equal(list, []any{"a", "b"}) {
+		t.Fatalf("list_field = %v", list)
+	}
+	if list := values["scalar_list_field"].([]any); !slices.Equal(list, []any{uint64(1), uint64(2)}) {
+		t.Fatalf("scalar_list_field = %v", list)
+	}
+	if _, err := book.Read(books.GetRootAsBook(data, 0).Table(), "isbn"); err == nil {
+		t.Fatal("Read succeeded on an undeclared field")
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	schema, err := flatmap.ParseSchema(`
+// comment
+namespace a.b;
+attribute "priority";
+enum Status : ubyte (bit_flags) { ON, OFF }
+enum Color : short { Red = 2, Green, Blue = 10 }
+struct Vec (force_align: 8) { x: float; y: byte; z: [int:2]; }
+table Cat { name: string; }
+table Dog { barks: bool = true; }
+union Pet { Cat, Doggo: Dog }
+/* multi
+line */
+table Root {
+  status: Status = OFF;
+  color: Color = Green (priority: 1);
+  pos: Vec;
+  pet: Pet;
+  pets: [Cat];
+  old: int (deprecated);
+  big: ulong = 0xFFFFFFFFFFFFFFFF;
+}
+root_type Root;
+file_identifier "ROOT";
+rpc_service S { Get(Root): Root; }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vec := schema.Object("Vec")
+	if vec.ByteSize != 16 || vec.MinAlign != 8 || vec.Fields[1].Offset != 4 || vec.Fields[2].Offset != 8 {
+		t.Fatalf("Vec is %d bytes aligned to %d, y at %d, z at %d", vec.ByteSize, vec.MinAlign, vec.Fields[1].Offset, vec.Fields[2].Offset)
+	}
+	root := schema.Object("a.b.Root")
+	if root.Field("pet_type").ID != 3 || root.Field("pet").ID != 4 || root.Field("big").Offset != 4+2*7 || !root.Field("old").Deprecated {
+		t.Fatal("a union takes two slots and a deprecated field keeps its own")
+	}
+	if root.Field("color").Attributes["priority"] != "1" {
+		t.Fatalf("color attributes = %v", root.Field("color").Attributes)
+	}
+
+	// status = OFF, color = Blue, pos, pet = Dog{barks: false}, pets = [Cat{name: "tom"}]
+	builder := flatbuffers.NewBuilder(0)
+	name := builder.CreateString("tom")
+	builder.StartObject(1)
+	builder.PrependUOffsetTSlot(0, name, 0)
+	cat := builder.EndObject()
+	builder.StartVector(4, 1, 4)
+	builder.PrependUOffsetT(cat)
+	pets := builder.EndVector(1)
+	builder.StartObject(1)
+	builder.PrependBoolSlot(0, false, true)
+	dog := builder.EndObject()
+	builder.StartObject(9)
+	builder.PrependUOffsetTSlot(5, pets, 0)
+	builder.PrependUOffsetTSlot(4, dog, 0)
+	builder.PrependByteSlot(3, 2, 0)
+	builder.Prep(8, 16)
+	builder.PrependInt32(7)
+	builder.PrependInt32(6)
+	builder.Pad(3)
+	builder.PrependInt8(-1)
+	builder.PrependFloat32(1.5)
+	builder.Slot(2)
+	builder.PrependInt16Slot(1, 10, 3)
+	builder.Finish(builder.EndObject())
+	buf := builder.FinishedBytes()
+
+	values, err := root.Project(flatbuffers.Table{Bytes: buf, Pos: flatbuffers.GetUOffsetT(buf)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["status"].(flatmap.EnumValue).Name != "OFF" || values["color"].(flatmap.EnumValue).Name != "Blue" || values["big"] != uint64(0xFFFFFFFFFFFFFFFF) {
+		t.Fatalf("status %v, color %v, big %v", values["status"], values["color"], values["big"])
+	}
+	pos := values["pos"].(flatmap.ObjectValue)
+	x, _ := pos.Read("x")
+	y, _ := pos.Read("y")
+	z, _ := pos.Read("z")
+	if x != float32(1.5) || y != int8(-1) || !slices.Equal(z.([]any), []any{int32(6), int32(7)}) {
+		t.Fatalf("pos = %v, %v, %v", x, y, z)
+	}
+	pet := values["pet"].(flatmap.ObjectValue)
+	if barks, _ := pet.Read("barks"); pet.Object.ShortName() != "Dog" || barks != false {
+		t.Fatalf("pet is a %s barking %v", pet.Object.ShortName(), barks)
+	}
+	if name, _ := values["pets"].([]any)[0].(flatmap.ObjectValue).Read("name"); name != "tom" {
+		t.Fatalf("pets[0].name = %v", name)
+	}
+}
+
+func TestParseBinarySchema(t *testing.T) {
+	// a reflection schema with a single table ns.T { id: ulong = 9; }
+	builder := flatbuffers.NewBuilder(0)
+	fieldName := builder.CreateString("id")
+	builder.StartObject(6)
+	builder.PrependInt8Slot(0, int8(flatmap.BaseTypeULong), 0)
+	fieldType := builder.EndObject()
+	builder.StartObject(14)
+	builder.PrependUOffsetTSlot(0, fieldName, 0)
+	builder.PrependUOffsetTSlot(1, fieldType, 0)
+	builder.PrependUint16Slot(3, 4, 0)
+	builder.PrependInt64Slot(4, 9, 0)
+	field := builder.EndObject()
+	builder.StartVector(4, 1, 4)
+	builder.PrependUOffsetT(field)
+	fields := builder.EndVector(1)
+	objectName := builder.CreateString("ns.T")
+	builder.StartObject(8)
+	builder.PrependUOffsetTSlot(0, objectName, 0)
+	builder.PrependUOffsetTSlot(1, fields, 0)
+	object := builder.EndObject()
+	builder.StartVector(4, 1, 4)
+	builder.PrependUOffsetT(object)
+	objects := builder.EndVector(1)
+	builder.StartVector(4, 0, 4)
+	enums := builder.EndVector(0)
+	builder.StartObject(8)
+	builder.PrependUOffsetTSlot(0, objects, 0)
+	builder.PrependUOffsetTSlot(1, enums, 0)
+	builder.PrependUOffsetTSlot(4, object, 0)
+	builder.FinishWithFileIdentifier(builder.EndObject(), []byte("BFBS"))
+
+	schema, err := flatmap.ParseBinarySchema(builder.FinishedBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.RootTable == nil || schema.Namespace != "ns" {
+		t.Fatalf("root table %v in namespace %q", schema.RootTable, schema.Namespace)
+	}
+	empty := flatbuffers.NewBuilder(0)
+	empty.StartObject(1)
+	empty.Finish(empty.EndObject())
+	buf := empty.FinishedBytes()
+	if id, err := schema.Object("T").Read(flatbuffers.Table{Bytes: buf, Pos: flatbuffers.GetUOffsetT(buf)}, "id"); err != nil || id != uint64(9) {
+		t.Fatalf("Read(id) = %v, %v, want the default 9", id, err)
+	}
+	if _, err := flatmap.ParseBinarySchema([]byte("garbage.")); err == nil {
+		t.Fatal("ParseBinarySchema accepted garbage")
+	}
+}
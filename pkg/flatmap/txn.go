@@ -0,0 +1,129 @@
+package flatmap
+
+import (
+	"iter"
+	"sync"
+)
+
+// ReadTxn serves reads from the tree version captured by BeginRead, so a request doing
+// several reads across shards sees one consistent dataset. The views and buffers of that
+// version are kept alive until Close; a long running transaction holds on to every shard
+// rebuilt in the meantime, so close it as soon as the request is done.
+type ReadTxn[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] struct {
+	root      *FlatNode[K, VT, V, VList]
+	version   uint64
+	closeOnce sync.Once
+}
+
+// BeginRead opens a read transaction on the latest committed version.
+func (sn *FlatNode[K, VT, V, VList]) BeginRead() *ReadTxn[K, VT, V, VList] {
+	clock := sn.conf.clock
+	clock.mu.Lock()
+	version := clock.committed.Load()
+	if clock.readers == nil {
+		clock.readers = make(map[uint64]int)
+	}
+	clock.readers[version]++
+	clock.mu.Unlock()
+	return &ReadTxn[K, VT, V, VList]{root: sn, version: version}
+}
+
+// Version returns the tree version the transaction reads from.
+func (txn *ReadTxn[K, VT, V, VList]) Version() uint64 {
+	return txn.version
+}
+
+// Get retrieves a value as of the transaction version. DO NOT PASS A NIL VALUE
+func (txn *ReadTxn[K, VT, V, VList]) Get(keys []K, v V) bool {
+	return txn.root.getAt(keys, v, txn.version)
+}
+
+// GetBatch returns the list of the shard at keys as of the transaction version.
+func (txn *ReadTxn[K, VT, V, VList]) GetBatch(keys []K) (vList VList, found bool) {
+	return txn.root.getBatchAt(keys, txn.version)
+}
+
+// GetBy returns the values indexed under value as of the transaction version, see
+// FlatNode.GetBy.
+func (txn *ReadTxn[K, VT, V, VList]) GetBy(indexName string, value any) ([]V, error) {
+	return txn.root.getByAt(indexName, value, txn.version)
+}
+
+// GetMany looks up many key paths as of the transaction version, see FlatNode.GetMany.
+func (txn *ReadTxn[K, VT, V, VList]) GetMany(keyPaths [][]K, fn func(i int, v V, ok bool)) {
+	txn.root.getMany(keyPaths, 0, txn.root.conf.NewV(), txn.version, fn)
+}
+
+// Iterate calls fn for every value under prefix as of the transaction version, shard by
+// shard in no particular order, until fn returns false. v is reused between calls.
+func (txn *ReadTxn[K, VT, V, VList]) Iterate(prefix []K, fn func(v V) bool) {
+	txn.root.iterateAt(prefix, txn.root.conf.NewV(), txn.version, fn)
+}
+
+// Ascend iterates the entries under prefix in ascending key order as of the transaction
+// version, see FlatNode.Ascend.
+func (txn *ReadTxn[K, VT, V, VList]) Ascend(prefix []K) iter.Seq2[K, V] {
+	return txn.root.orderedAt(prefix, nil, nil, false, txn.version)
+}
+
+// Descend iterates the entries under prefix in descending key order as of the transaction
+// version, see FlatNode.Descend.
+func (txn *ReadTxn[K, VT, V, VList]) Descend(prefix []K) iter.Seq2[K, V] {
+	return txn.root.orderedAt(prefix, nil, nil, true, txn.version)
+}
+
+// Range iterates the entries under prefix whose key is in [from, to) as of the transaction
+// version, see FlatNode.Range.
+func (txn *ReadTxn[K, VT, V, VList]) Range(prefix []K, from, to K) iter.Seq2[K, V] {
+	return txn.root.orderedAt(prefix, &from, &to, false, txn.version)
+}
+
+// Close releases the version, its views can be dropped by the next rebuilds.
+func (txn *ReadTxn[K, VT, V, VList]) Close() {
+	txn.closeOnce.Do(func() {
+		clock := txn.root.conf.clock
+		clock.mu.Lock()
+		if clock.readers[txn.version]--; clock.readers[txn.version] == 0 {
+			delete(clock.readers, txn.version)
+		}
+		clock.mu.Unlock()
+	})
+}
+
+func (sn *FlatNode[K, VT, V, VList]) iterateAt(prefix []K, v V, version uint64, fn func(v V) bool) bool {
+	switch sn.nodeType {
+	case NodeNonLeaf:
+		if len(prefix) > sn.level {
+			child, ok := sn.children[prefix[sn.level]]
+			if !ok {
+				return true
+			}
+			return child.iterateAt(prefix, v, version, fn)
+		}
+		for _, child := range sn.children {
+			if !child.iterateAt(prefix, v, version, fn) {
+				return false
+			}
+		}
+		return true
+	case NodeLeaf:
+		view := sn.viewAt(version)
+		if view.buffer == nil {
+			return true
+		}
+		if len(prefix) > sn.level {
+			index, ok := sn.lookup(view, prefix[sn.level], v)
+			if !ok || !view.Vlist.Children(v, index) {
+				return true
+			}
+			return fn(v)
+		}
+		for i := 0; i < view.Vlist.ChildrenLength(); i++ {
+			if view.Vlist.Children(v, i) && !fn(v) {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
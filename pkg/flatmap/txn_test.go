@@ -0,0 +1,54 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestReadTxnIsolation(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(2), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, 5, 0), bookDelta(2, 12, 5, 0), bookDelta(2, 21, 5, 0)})
+
+	txn := node.BeginRead()
+	book := &books.Book{}
+	for i := uint64(6); i < 50; i++ {
+		node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 11, i, 0), bookDelta(2, 21, i, 0)})
+		for _, keys := range [][]int{{1, 11}, {1, 21}} {
+			if !txn.Get(keys, book) || book.PageCount() != 5 {
+				t.Fatalf("transaction read %d pages for %v, want 5", book.PageCount(), keys)
+			}
+		}
+	}
+	expectPageCount(t, node, []int{1, 11}, 49)
+
+	count := 0
+	txn.Iterate(nil, func(b *books.Book) bool {
+		if b.PageCount() != 5 {
+			t.Fatalf("transaction iterated %d pages, want 5", b.PageCount())
+		}
+		count++
+		return true
+	})
+	if count != 3 {
+		t.Fatalf("transaction iterated %d books, want 3", count)
+	}
+	count = 0
+	txn.Iterate([]int{1}, func(*books.Book) bool { count++; return true })
+	if count != 2 {
+		t.Fatalf("transaction iterated %d books under [1], want 2", count)
+	}
+	txn.Close()
+	txn.Close() // closing twice is harmless
+
+	latest := node.BeginRead()
+	defer latest.Close()
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(2, 21, 100, 0)})
+	if !latest.Get([]int{1, 21}, book) || book.PageCount() != 49 {
+		t.Fatalf("transaction read %d pages, want 49", book.PageCount())
+	}
+	if latest.Version() >= node.Version() {
+		t.Fatalf("transaction version %d is not behind the tree version %d", latest.Version(), node.Version())
+	}
+}
@@ -1,6 +1,8 @@
 package flatmap
 
 import (
+	"sync"
+
 	flatbuffers "github.com/google/flatbuffers/go"
 )
 
@@ -23,6 +25,27 @@ type VListType[VT VTypeT, V VType[VT]] interface {
 type DeltaItem[K comparable] struct {
 	Keys []K
 	Data []byte
+	// Version is an optional per-key sequence number. When non-zero, the delta only
+	// replaces an entry holding an older version; stale deltas are dropped and counted.
+	// Zero means unversioned: the delta always applies, as it did before versioning.
+	Version uint64
+	// Patch marks Data as a partial V: only the fields present in it replace the ones of
+	// the existing entry, absent fields are preserved. Fields equal to their default are
+	// not written by flatbuffers, so a patch cannot reset a field to its default.
+	Patch bool
+	// Delete removes the entry at Keys, Data is ignored. It is subject to Version like a
+	// write, a later delta for the same key restores the entry.
+	Delete bool
+}
+
+// deferredDelta is a delta resolved against the entry in the current view at rebuild time.
+// It is applied only if check (when set) holds, version being the version of that entry,
+// 0 when missing or unversioned. merge (when set) computes the new value from the old one.
+type deferredDelta[K comparable, VT VTypeT, V VType[VT]] struct {
+	delta  DeltaItem[K]
+	check  func(old V, exists bool, version uint64) bool
+	merge  func(old VT) VT
+	result chan bool // nil when nobody waits for the outcome
 }
 
 // (Note: for enums we treat them as int8.)
@@ -30,17 +53,48 @@ type FlatConfig[K comparable, VT VTypeT, V VType[VT], VList VListType[VT, V]] st
 	// Public fields
 	Name            string
 	NewV            func() V
-	NewVList        func() VList
+	NewVList        func() VList // optional, only used by GetBatch and GetRootAsVList
 	GetKeysFromV    func(v V) []K
+	AppendKeysFromV func(dst []K, v V) []K // optional, appends the keys of v to dst, used instead of GetKeysFromV
 	CheckVForDelete func(v V) bool
+	GetVersionFromV func(v V) uint64      // optional, used when DeltaItem.Version is not set
+	TombstoneWindow uint64                // optional, forgets delete versions this far behind the leaf's newest, 0 keeps them
+	MergePatch      func(dst VT, patch V) // optional, overlays a patch delta, resolved through Schema when nil
 	UpdateSeconds   uint
+	HistoryDepth    int              // optional, superseded views each leaf retains for GetAt and SnapshotAt
+	HistoryBytes    int              // optional, caps the bytes of the views a leaf retains, 0 means no cap
+	Indexes         []IndexDef[V]    // optional secondary indexes, see NewIndex and GetBy
+	CompareKeys     func(a, b K) int // optional, e.g. cmp.Compare[int], enables Range, Ascend and Descend
+	SortedLayout    bool             // optional, stores shards sorted by key and binary searches them, needs CompareKeys
+	Schema          *SchemaObject    // optional, the table of V: ExportJSON output, enum names in DecodeJSON, patch merges without MergePatch
 	SnapShotMode    SnapshotMode
 	Logger          Logger
 	LogLevel        LogLevel
+
+	// optional, builds the key index of a leaf instead of a map, e.g. NewIntKeyIndex[int]
+	NewKeyIndex func(keys []K) KeyIndex[K]
+	// optional, e.g. OpenIntKeyIndex[int], embeds the EncodedKeyIndex built by NewKeyIndex in
+	// the leaf buffers and reads it back in place, from snapshots too
+	OpenKeyIndex func(data []byte) (KeyIndex[K], error)
+
+	// Bloom filters per leaf, rejecting most missing keys before the index lookup
+	FilterFalsePositiveRate float64            // optional, e.g. 0.01, 0 disables the filters
+	HashKey                 func(key K) uint64 // optional, integers, strings and Key have a default
+
+	// Private fields, shared by all the nodes of a tree
+	clock      *treeClock
+	keyBuffers *sync.Pool    // *[]K for AppendKeysFromV, see keyOf
+	probes     *sync.Pool    // V for GetInto, Has and GetMany
+	done       chan struct{} // closed by Close to stop PeriodicUpdate
+	closeOnce  *sync.Once
+	hashKey    func(K) uint64        // HashKey or defaultHashKey, nil without filters
+	mergePatch func(dst VT, patch V) // see resolveMergePatch, nil rejects patch deltas
 }
 
 type ShardSnapshot[K comparable] struct {
-	Path   []K
-	Keys   []K
-	Buffer []byte
+	Path    []K // keys of the levels above the leaf, empty on a one level tree
+	Keys    []K
+	Buffer  []byte
+	Version uint64 // max applied delta version in the shard
+	Filter  []byte // optional bloom filter of the keys, see FlatConfig.FilterFalsePositiveRate
 }
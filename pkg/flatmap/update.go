@@ -1,15 +1,24 @@
 package flatmap
 
 import (
+	"maps"
 	"time"
 
 	flatbuffers "github.com/google/flatbuffers/go"
 )
 
 func (sn *FlatNode[K, VT, V, VList]) PeriodicUpdate() {
+	interval := time.Duration(sn.conf.UpdateSeconds) * time.Second
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
 	for {
-		time.Sleep(time.Duration(sn.conf.UpdateSeconds) * time.Second)
-		if len(sn.pendingDelta) == 0 && len(sn.deleted) == 0 {
+		select {
+		case <-sn.conf.done:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+		if len(sn.pendingDelta) == 0 && len(sn.pendingDeferred) == 0 && len(sn.deleted) == 0 && sn.shardSnapshot == nil {
 			continue
 		}
 		sn.Update(nil)
@@ -31,11 +40,13 @@ func (sn *FlatNode[K, VT, V, VList]) DecideNodeType() {
 			sn.EnsureCapacity()
 			return
 		}
-		if len(sn.pendingDelta) == 0 {
+		if len(sn.pendingDelta) == 0 && len(sn.pendingDeferred) == 0 {
 			return
 		}
-		if len(sn.pendingDelta) != 0 && len(sn.pendingDelta[0].Keys) != 0 {
+		if len(sn.pendingDelta) != 0 {
 			keyLen = len(sn.pendingDelta[0].Keys)
+		} else {
+			keyLen = len(sn.pendingDeferred[0].delta.Keys)
 		}
 		if keyLen == 0 {
 			panic("invalid data") //TODO: gracefully handle it
@@ -115,29 +126,34 @@ func (sn *FlatNode[K, VT, V, VList]) appendBulkDeltaIfNeeded(bulkDelta []DeltaIt
 
 func (sn *FlatNode[K, VT, V, VList]) updateLeafNode() {
 	if sn.shardSnapshot != nil && sn.conf.SnapShotMode == SnapshotModeConsumer {
+		// the snapshot replaces the shard content, pending data was reset with it
 		sn.initializeLeafFromSnapshot()
+		return
 	}
 	startTime := time.Now()
 
-	pendingKeys := sn.collectPendingKeys()
+	pendingKeys, pendingVersions := sn.collectPendingKeys()
+	deferredResults, pendingVersions := sn.resolveDeferredDeltas(pendingKeys, pendingVersions)
 
 	// if it is the first time, we need to initialize the buffers
 	var childrenLen int
+	if len(sn.ReadBuffer) != 0 {
+		childrenLen = sn.viewPtr.Load().Vlist.ChildrenLength()
+	}
 	if sn.Builder == nil {
 		sn.initializeBuffers(pendingKeys)
-	} else {
-		childrenLen = sn.viewPtr.Vlist.ChildrenLength()
 	}
 
 	// Process and update the data
-	sn.processLeafData(pendingKeys, childrenLen)
+	sn.processLeafData(pendingKeys, pendingVersions, childrenLen)
+	sn.notifyDeferredDeltas(deferredResults)
 
 	// If the finished buffer is %75 or more full(1.5GB), indicate that
-	elemSize := sn.viewPtr.Vlist.ChildrenLength()
+	elemSize := sn.viewPtr.Load().Vlist.ChildrenLength()
 	if elemSize > 0 { // 1.5GB
 		firstElem := sn.conf.NewV()
-		sn.viewPtr.Vlist.Children(firstElem, 0)
-		keys := sn.conf.GetKeysFromV(firstElem)
+		sn.viewPtr.Load().Vlist.Children(firstElem, 0)
+		keys := sn.appendKeys(nil, firstElem)
 		floatSize := float64(len(sn.ReadBuffer)) / (1024 * 1024 * 1024)
 		logLevel := InfoLevel
 		if floatSize > 1.5 { // Crash if we go over 2GB
@@ -149,21 +165,129 @@ func (sn *FlatNode[K, VT, V, VList]) updateLeafNode() {
 }
 
 func (sn *FlatNode[K, VT, V, VList]) initializeLeafFromSnapshot() {
-	vList := sn.GetRootAsVList(sn.shardSnapshot.Buffer)
-	sn.viewPtr.Vlist = vList
+	snapshot := sn.shardSnapshot
 	sn.shardSnapshot = nil
+	vList := sn.leafList(snapshot.Buffer)
+	var keys []K
+	indexes := sn.embeddedKeyIndex(snapshot.Buffer)
+	if indexes == nil && !sn.conf.SortedLayout {
+		keys = snapshot.Keys
+		if keys == nil { // self-indexing snapshot of a sorted layout
+			keys = sn.listKeys(vList, sn.level)
+		}
+		indexes = sn.keyIndexOf(keys)
+	}
+	sn.publishView(&View[K, VT, V, VList]{
+		indexes:    indexes,
+		Vlist:      vList,
+		maxVersion: snapshot.Version,
+		buffer:     snapshot.Buffer,
+		filter:     sn.snapshotFilter(snapshot, vList, keys),
+
+		floorVersion: snapshot.Version, // the snapshot holds every delta up to its version
+	})
 	sn.pendingDelta = make([]DeltaItem[K], 0, 16) // Provide initial capacity
 	sn.deleted = make(map[K]struct{})
-	for i, k := range sn.shardSnapshot.Keys {
-		sn.viewPtr.indexes[k] = i
+	sn.pendingKeys = make(map[K]struct{}, len(snapshot.Keys))
+	// the buffer is only read: rebuilds build a new one and mutations copy
+	// the shard into the write buffers first
+	sn.ReadBuffer = snapshot.Buffer
+}
+
+// resolveDeferredDeltas evaluates the queued deferred deltas in arrival order, after the plain
+// deltas were collected. A condition or merge sees the current view with the pending deltas
+// of the same cycle applied, including the deferred ones accepted before it; an accepted
+// delta is still subject to the version check. It returns whether each write is applied.
+func (sn *FlatNode[K, VT, V, VList]) resolveDeferredDeltas(
+	pendingKeys map[K]int,
+	pendingVersions map[K]uint64,
+) ([]bool, map[K]uint64) {
+	if len(sn.pendingDeferred) == 0 {
+		return nil, pendingVersions
+	}
+	results := make([]bool, len(sn.pendingDeferred))
+	var old V = sn.conf.NewV()
+	var patch V = sn.conf.NewV()
+	var stale uint64
+	for i := range sn.pendingDeferred {
+		dd := &sn.pendingDeferred[i]
+		key := dd.delta.Keys[sn.level]
+		var exists bool
+		var version uint64
+		if at, ok := pendingKeys[key]; ok && !sn.pendingDelta[at].Delete {
+			pending := &sn.pendingDelta[at]
+			if pending.Patch && sn.liveEntry(key, old) {
+				sn.GetRootAsV(pending.Data, patch)
+				pending.Data = sn.overlayPatch(old, patch)
+				pending.Patch = false
+			}
+			version = sn.deltaVersion(pending, old)
+			sn.GetRootAsV(pending.Data, old)
+			exists = true
+		} else if !ok && sn.liveEntry(key, old) { // missing when deleted in this cycle
+			version = sn.entryVersion(key, old)
+			exists = true
+		}
+		if dd.check != nil && !dd.check(old, exists, version) {
+			continue
+		}
+		delta := dd.delta
+		if dd.merge != nil {
+			var oldVT VT
+			if exists {
+				oldVT = old.UnPack()
+			}
+			delta.Data = packV(dd.merge(oldVT))
+			delta.Patch = false
+		} else if delta.Patch && exists {
+			sn.GetRootAsV(delta.Data, patch)
+			delta.Data = sn.overlayPatch(old, patch)
+			delta.Patch = false
+		}
+		deltaVersion := sn.deltaVersion(&delta, old)
+		if deltaVersion != 0 {
+			latest, ok := pendingVersions[key]
+			if !ok {
+				latest = sn.entryVersion(key, old)
+			}
+			if deltaVersion <= latest {
+				stale++
+				continue
+			}
+			if pendingVersions == nil {
+				pendingVersions = make(map[K]uint64, len(sn.pendingDeferred))
+			}
+		}
+		if pendingVersions != nil {
+			pendingVersions[key] = deltaVersion
+		}
+		pendingKeys[key] = len(sn.pendingDelta)
+		sn.pendingDelta = append(sn.pendingDelta, delta)
+		results[i] = true
+	}
+	if stale != 0 {
+		sn.staleDeltas.Add(stale)
+	}
+	return results, pendingVersions
+}
+
+// notifyDeferredDeltas reports the outcome of the deferred writes once the view is published.
+func (sn *FlatNode[K, VT, V, VList]) notifyDeferredDeltas(results []bool) {
+	for i := range results {
+		if sn.pendingDeferred[i].result != nil {
+			sn.pendingDeferred[i].result <- results[i]
+		}
 	}
-	sn.pendingKeys = make(map[K]struct{}, len(sn.shardSnapshot.Keys))
-	sn.ReadBuffer = sn.shardSnapshot.Buffer
+	clear(sn.pendingDeferred)
+	sn.pendingDeferred = sn.pendingDeferred[:0]
 }
 
-func (sn *FlatNode[K, VT, V, VList]) collectPendingKeys() map[K]int {
+// collectPendingKeys picks the delta to apply for each pending key. Unversioned deltas
+// follow slice order, versioned ones only win over an older version of the same key,
+// whether it is already in the view or pending in the same batch. Patches are folded
+// over the delta they replace. pendingVersions is nil unless versioning is in use.
+func (sn *FlatNode[K, VT, V, VList]) collectPendingKeys() (pendingKeys map[K]int, pendingVersions map[K]uint64) {
 	expectedSize := len(sn.pendingDelta)
-	var pendingKeys map[K]int
 
 	// Create map with appropriate initial capacity
 	if expectedSize > 0 {
@@ -172,28 +296,119 @@ func (sn *FlatNode[K, VT, V, VList]) collectPendingKeys() map[K]int {
 		pendingKeys = make(map[K]int)
 	}
 
-	for i := len(sn.pendingDelta) - 1; i >= 0; i-- { // to take the latest data when there are duplicates
-		if _, ok := pendingKeys[sn.pendingDelta[i].Keys[sn.level]]; ok {
+	versioned := sn.hasVersionedDeltas()
+	if versioned {
+		pendingVersions = make(map[K]uint64, expectedSize)
+	}
+	var vObj V = sn.conf.NewV()
+	var patch V = sn.conf.NewV()
+	var stale, unmergeable uint64
+	for i := range sn.pendingDelta {
+		if sn.checkPatch(&sn.pendingDelta[i]) != nil {
+			unmergeable++ // fed through FeedDeltaBulk, the other paths reject it
 			continue
 		}
-		pendingKeys[sn.pendingDelta[i].Keys[sn.level]] = i
+		key := sn.pendingDelta[i].Keys[sn.level]
+		if versioned {
+			version := sn.deltaVersion(&sn.pendingDelta[i], vObj)
+			if version != 0 {
+				latest, ok := pendingVersions[key]
+				if !ok {
+					latest = sn.entryVersion(key, vObj)
+				}
+				if version <= latest {
+					stale++
+					continue
+				}
+			}
+			pendingVersions[key] = version
+		}
+		if prev, ok := pendingKeys[key]; ok && sn.pendingDelta[i].Patch {
+			if sn.pendingDelta[prev].Delete {
+				sn.pendingDelta[i].Patch = false // nothing left to patch
+			} else {
+				sn.foldPatch(prev, i, vObj, patch)
+			}
+		}
+		pendingKeys[key] = i // the latest data wins when there are duplicates
+	}
+	if stale != 0 {
+		sn.staleDeltas.Add(stale)
+		sn.logf(DebugLevel, "%s dropped %d stale deltas, level: %d\n", sn.conf.Name, stale, sn.level)
 	}
+	if unmergeable != 0 {
+		sn.logf(ErrorLevel, "%s dropped %d patch deltas: %v, level: %d\n", sn.conf.Name, unmergeable, errNoMergePatch, sn.level)
+	}
+	return pendingKeys, pendingVersions
+}
 
-	return pendingKeys
+// liveEntry points v at the entry of key in the current view, ignoring deleted ones.
+func (sn *FlatNode[K, VT, V, VList]) liveEntry(key K, v V) bool {
+	view := sn.viewPtr.Load() // never nil
+	index, ok := sn.lookup(view, key, v)
+	if !ok {
+		return false
+	}
+	if _, deleted := sn.deleted[key]; deleted {
+		return false
+	}
+	return view.Vlist.Children(v, index)
+}
+
+func (sn *FlatNode[K, VT, V, VList]) hasVersionedDeltas() bool {
+	if sn.conf.GetVersionFromV != nil {
+		return true
+	}
+	if view := sn.viewPtr.Load(); view.versions != nil || view.tombstones != nil || view.floorVersion != 0 {
+		return true
+	}
+	for i := range sn.pendingDelta {
+		if sn.pendingDelta[i].Version != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// deltaVersion returns the version carried by the delta, falling back to GetVersionFromV.
+func (sn *FlatNode[K, VT, V, VList]) deltaVersion(delta *DeltaItem[K], vObj V) uint64 {
+	if delta.Version != 0 || sn.conf.GetVersionFromV == nil || delta.Delete {
+		return delta.Version
+	}
+	sn.GetRootAsV(delta.Data, vObj)
+	return sn.conf.GetVersionFromV(vObj)
+}
+
+// entryVersion returns the version of the entry currently in the view, or of the delete
+// that removed it. It is never below the version of a restored snapshot, 0 if unknown.
+func (sn *FlatNode[K, VT, V, VList]) entryVersion(key K, vObj V) uint64 {
+	view := sn.viewPtr.Load()
+	if sn.conf.GetVersionFromV == nil {
+		if version, ok := view.versions[key]; ok {
+			return max(version, view.floorVersion)
+		}
+		return max(view.tombstones[key], view.floorVersion)
+	}
+	index, ok := sn.lookup(view, key, vObj)
+	if !ok || !view.Vlist.Children(vObj, index) {
+		return max(view.tombstones[key], view.floorVersion)
+	}
+	return max(sn.conf.GetVersionFromV(vObj), view.floorVersion)
 }
 
 func (sn *FlatNode[K, VT, V, VList]) initializeBuffers(pendingKeys map[K]int) {
 	// Size buffers according to expected data size
 	initialSize := max(1024, estimateBufferSize(len(pendingKeys)))
-	sn.Builder = flatbuffers.NewBuilder(initialSize)
-	sn.ReadBuffer = make([]byte, 0, initialSize)
-	sn.WriteBuffer = make([]byte, 0, initialSize)
-	sn.BackupBuffer = make([]byte, 0, initialSize)
+	sn.Builder = flatbuffers.NewBuilder(0) // every rebuild allocates its own bytes
+	if sn.ReadBuffer == nil {              // may already hold a snapshot
+		sn.ReadBuffer = make([]byte, 0, initialSize)
+	}
 }
 
-func (sn *FlatNode[K, VT, V, VList]) processLeafData(pendingKeys map[K]int, childrenLen int) {
-	// reuse backup buffer
-	sn.Builder.Bytes = sn.WriteBuffer
+func (sn *FlatNode[K, VT, V, VList]) processLeafData(pendingKeys map[K]int, pendingVersions map[K]uint64, childrenLen int) {
+	// build into a new buffer, a published one is never reused: values returned by Get and
+	// snapshots keep reading the buffer they came from, caller buffers are never written
+	sn.Builder.Bytes = make([]byte, 0, len(sn.ReadBuffer)+estimateBufferSize(len(pendingKeys)))
 	sn.Builder.Reset()
 
 	// Estimate proper capacity for maps and slices
@@ -207,8 +422,74 @@ func (sn *FlatNode[K, VT, V, VList]) processLeafData(pendingKeys map[K]int, chil
 	// Then process pending deltas
 	newIndexes, newOffsets = sn.processPendingDeltas(newIndexes, newOffsets, pendingKeys)
 
+	newView := &View[K, VT, V, VList]{floorVersion: sn.viewPtr.Load().floorVersion}
+	newView.versions, newView.tombstones, newView.maxVersion = sn.mergeVersions(newIndexes, pendingVersions)
+	if sn.conf.SortedLayout {
+		sn.sortOffsets(newIndexes, newOffsets) // the buffer is searched instead of an index
+	} else {
+		newView.indexes = sn.keyIndex(newIndexes)
+	}
+
 	// Build and update the flatbuffer
-	sn.buildAndUpdateFlatBuffer(newIndexes, newOffsets)
+	sn.buildAndUpdateFlatBuffer(newView, newOffsets, newIndexes)
+	clear(sn.deleted) // applied, the keys may be written again
+}
+
+// mergeVersions carries the versions of retained entries over and records the applied ones,
+// the versions of deleted entries are kept as tombstones.
+func (sn *FlatNode[K, VT, V, VList]) mergeVersions(newIndexes map[K]int, pendingVersions map[K]uint64) (versions, tombstones map[K]uint64, maxVersion uint64) {
+	view := sn.viewPtr.Load() // never nil
+	oldVersions := view.versions
+	maxVersion = view.maxVersion
+	for _, version := range pendingVersions {
+		maxVersion = max(maxVersion, version)
+	}
+	tombstones = sn.mergeTombstones(newIndexes, pendingVersions, maxVersion)
+	if sn.conf.GetVersionFromV != nil || (oldVersions == nil && maxVersion == 0) {
+		return nil, tombstones, maxVersion // versions are either read from the entries or not used
+	}
+	versions = make(map[K]uint64, len(oldVersions)+len(pendingVersions))
+	for key := range newIndexes {
+		version, ok := pendingVersions[key]
+		if !ok {
+			version = oldVersions[key]
+		}
+		if version != 0 {
+			versions[key] = version
+		}
+	}
+	return versions, tombstones, maxVersion
+}
+
+// mergeTombstones records the versions of the keys removed by versioned deletes, so a write
+// older than the delete is still stale. A tombstone is dropped when its key is written again,
+// or once it falls more than FlatConfig.TombstoneWindow behind maxVersion.
+func (sn *FlatNode[K, VT, V, VList]) mergeTombstones(newIndexes map[K]int, pendingVersions map[K]uint64, maxVersion uint64) map[K]uint64 {
+	var tombstones map[K]uint64
+	keep := func(key K, version uint64) {
+		if version == 0 {
+			return
+		}
+		if _, ok := newIndexes[key]; ok {
+			return
+		}
+		if window := sn.conf.TombstoneWindow; window != 0 && maxVersion-version > window {
+			return
+		}
+		if tombstones == nil {
+			tombstones = make(map[K]uint64)
+		}
+		tombstones[key] = version
+	}
+	for key, version := range sn.viewPtr.Load().tombstones {
+		if _, ok := pendingVersions[key]; !ok {
+			keep(key, version)
+		}
+	}
+	for key, version := range pendingVersions {
+		keep(key, version)
+	}
+	return tombstones
 }
 
 func (sn *FlatNode[K, VT, V, VList]) processExistingChildren(
@@ -220,18 +501,18 @@ func (sn *FlatNode[K, VT, V, VList]) processExistingChildren(
 	// Create a reusable object for VT rather than creating one per iteration
 	var vt VT
 	var vObj V = sn.conf.NewV()
-	view := *sn.viewPtr // never nil
+	view := sn.viewPtr.Load() // never nil
 
 	for i := range childrenLen {
 		created := view.Vlist.Children(vObj, i)
 		if !created {
 			continue
 		}
-		keys := sn.conf.GetKeysFromV(vObj)
-		if _, ok := sn.deleted[keys[sn.level]]; ok {
+		key := sn.keyOf(vObj, sn.level)
+		if _, ok := sn.deleted[key]; ok {
 			continue
 		}
-		if _, ok := pendingKeys[keys[sn.level]]; ok {
+		if _, ok := pendingKeys[key]; ok {
 			continue
 		}
 
@@ -240,7 +521,7 @@ func (sn *FlatNode[K, VT, V, VList]) processExistingChildren(
 		} else {
 			vObj.UnPackTo(vt)
 		}
-		newIndexes[keys[sn.level]] = len(newOffsets)
+		newIndexes[key] = len(newOffsets)
 		newOffsets = append(newOffsets, vt.Pack(sn.Builder))
 	}
 	return newIndexes, newOffsets
@@ -255,10 +536,17 @@ func (sn *FlatNode[K, VT, V, VList]) processPendingDeltas(
 	var vt VT
 	vtInitialized := false
 	var vObj V = sn.conf.NewV()
+	var oldObj V = sn.conf.NewV()
 
 	for _, i := range pendingKeys {
 		delta := sn.pendingDelta[i]
+		if delta.Delete {
+			continue
+		}
 		sn.GetRootAsV(delta.Data, vObj)
+		if delta.Patch && sn.liveEntry(delta.Keys[sn.level], oldObj) {
+			sn.GetRootAsV(sn.overlayPatch(oldObj, vObj), vObj)
+		}
 		if deleteFuncSet && sn.conf.CheckVForDelete(vObj) {
 			continue
 		}
@@ -275,40 +563,41 @@ func (sn *FlatNode[K, VT, V, VList]) processPendingDeltas(
 }
 
 func (sn *FlatNode[K, VT, V, VList]) buildAndUpdateFlatBuffer(
-	newIndexes map[K]int,
+	newView *View[K, VT, V, VList],
 	newOffsets []flatbuffers.UOffsetT,
+	newIndexes map[K]int,
 ) {
+	newView.filter = sn.buildFilter(len(newIndexes), maps.Keys(newIndexes))
 	sn.VListStartChildrenVector(sn.Builder, len(newOffsets))
 	for i := len(newOffsets) - 1; i >= 0; i-- {
 		sn.Builder.PrependUOffsetT(newOffsets[i])
 	}
 	vVector := sn.Builder.EndVector(len(newOffsets))
+	var keyIndexVector flatbuffers.UOffsetT
+	encoded, embed := newView.indexes.(EncodedKeyIndex)
+	if embed = embed && sn.conf.OpenKeyIndex != nil; embed {
+		keyIndexVector = sn.Builder.CreateByteVector(encoded.Bytes())
+	}
 	sn.VListStart(sn.Builder)
 	sn.VListAddChildren(sn.Builder, vVector)
+	if embed {
+		sn.VListAddKeyIndex(sn.Builder, keyIndexVector)
+	}
 	vListOffset := sn.End(sn.Builder)
 	sn.Builder.Finish(vListOffset)
 
-	// Update buffers with properly sized allocations if needed
-	finishedBytes := sn.Builder.FinishedBytes()
-	finishedSize := len(finishedBytes)
-
-	// Grow buffers if they're significantly smaller than needed
-	if cap(sn.BackupBuffer) < finishedSize*2/3 {
-		newSize := finishedSize * 2
-		sn.BackupBuffer = make([]byte, 0, newSize)
-	}
-
-	oldRead := sn.ReadBuffer
-	sn.ReadBuffer = finishedBytes
-	sn.WriteBuffer = sn.BackupBuffer
-	sn.BackupBuffer = oldRead
+	sn.ReadBuffer = sn.Builder.FinishedBytes()
+	sn.Builder.Bytes = nil // owned by the view from now on
 
-	newView := &View[K, VT, V, VList]{
-		indexes: newIndexes,
-		Vlist:   sn.GetRootAsVList(sn.ReadBuffer),
+	newView.Vlist = sn.leafList(sn.ReadBuffer)
+	newView.buffer = sn.ReadBuffer
+	if embed { // read in place, the built index is dropped
+		if index := sn.embeddedKeyIndex(sn.ReadBuffer); index != nil {
+			newView.indexes = index
+		}
 	}
 	// Update the view pointer
-	sn.viewPtr = newView
+	sn.publishView(newView)
 	// Clear without reallocation
 	sn.pendingDelta = sn.pendingDelta[:0]
 }
@@ -321,10 +610,34 @@ func (sn *FlatNode[K, VT, V, VList]) updateNonLeafNode() {
 	sn.pendingDelta = sn.pendingDelta[:0]
 
 	// Create or update child nodes
-	newKeys := sn.prepareChildNodes(groupedDeltas)
+	keys := sn.prepareChildNodes(groupedDeltas)
+
+	// Conditional deltas are evaluated by the leaves, hand them over before the update
+	keys = sn.distributeDeferredDeltas(keys, groupedDeltas)
 
 	// Process child nodes in parallel
-	sn.processChildNodesInParallel(newKeys, groupedDeltas)
+	sn.processChildNodesInParallel(keys, groupedDeltas)
+}
+
+func (sn *FlatNode[K, VT, V, VList]) distributeDeferredDeltas(keys []K, groupedDeltas map[K][]DeltaItem[K]) []K {
+	for _, cd := range sn.pendingDeferred {
+		key := cd.delta.Keys[sn.level]
+		child, ok := sn.children[key]
+		if !ok {
+			child = NewFlatNode(sn.conf, sn.level+1)
+			sn.children[key] = child
+		}
+		if _, ok := groupedDeltas[key]; !ok {
+			groupedDeltas[key] = nil
+			keys = append(keys, key)
+		}
+		child.rwMutex.Lock()
+		child.pendingDeferred = append(child.pendingDeferred, cd)
+		child.rwMutex.Unlock()
+	}
+	clear(sn.pendingDeferred)
+	sn.pendingDeferred = sn.pendingDeferred[:0]
+	return keys
 }
 
 func (sn *FlatNode[K, VT, V, VList]) groupDeltasByNextLevelKey() map[K][]DeltaItem[K] {
@@ -341,32 +654,26 @@ func (sn *FlatNode[K, VT, V, VList]) groupDeltasByNextLevelKey() map[K][]DeltaIt
 	return groupedDelta
 }
 
+// prepareChildNodes creates the missing children and returns every key that has deltas.
+// Deltas for existing children end up here when they are fed in bulk from above.
 func (sn *FlatNode[K, VT, V, VList]) prepareChildNodes(groupedDeltas map[K][]DeltaItem[K]) []K {
-	// Pre-allocate newKeys slice based on expected number of new children
-	numNewKeys := 0
-	for key := range groupedDeltas {
-		if _, ok := sn.children[key]; !ok {
-			numNewKeys++
-		}
-	}
-
-	newKeys := make([]K, 0, numNewKeys)
+	keys := make([]K, 0, len(groupedDeltas))
 	for key := range groupedDeltas {
 		if _, ok := sn.children[key]; !ok {
 			sn.children[key] = NewFlatNode(sn.conf, sn.level+1)
-			newKeys = append(newKeys, key)
 		}
+		keys = append(keys, key)
 	}
-	return newKeys
+	return keys
 }
 
-func (sn *FlatNode[K, VT, V, VList]) processChildNodesInParallel(newKeys []K, groupedDeltas map[K][]DeltaItem[K]) {
-	for _, key := range newKeys {
+func (sn *FlatNode[K, VT, V, VList]) processChildNodesInParallel(keys []K, groupedDeltas map[K][]DeltaItem[K]) {
+	for _, key := range keys {
 		sn.initializationWG.Add(1)
-		go func(key K) {
-			sn.children[key].FeedDeltaBulk(groupedDeltas[key])
+		go func(child *FlatNode[K, VT, V, VList]) {
+			child.Update(groupedDeltas[key])
 			sn.initializationWG.Done()
-		}(key)
+		}(sn.children[key])
 	}
 
 	sn.initializationWG.Wait()
@@ -0,0 +1,129 @@
+package flatmap_test
+
+import (
+	"testing"
+
+	"github.com/nidyaonur/flatmap/example/books"
+	"github.com/nidyaonur/flatmap/pkg/flatmap"
+)
+
+func TestVersionedDeltas(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 5), bookDelta(1, 1, 20, 3), bookDelta(1, 2, 7, 0)})
+	expectPageCount(t, node, []int{1}, 10)
+	if stale := node.StaleDeltaCount(); stale != 1 {
+		t.Fatalf("StaleDeltaCount() = %d, want 1", stale)
+	}
+
+	node.Set(bookDelta(1, 1, 30, 4)) // older than the entry
+	node.Set(bookDelta(1, 2, 8, 0))  // unversioned, always applied
+	node.Update(nil)
+	expectPageCount(t, node, []int{1}, 10)
+	expectPageCount(t, node, []int{2}, 8)
+
+	node.Set(bookDelta(1, 1, 40, 6))
+	node.Update(nil)
+	expectPageCount(t, node, []int{1}, 40)
+	if stale := node.StaleDeltaCount(); stale != 2 {
+		t.Fatalf("StaleDeltaCount() = %d, want 2", stale)
+	}
+
+	snapshot := node.GetSnapshot([]int{1}, true)
+	if snapshot.Version != 6 {
+		t.Fatalf("snapshot version = %d, want 6", snapshot.Version)
+	}
+	restored := flatmap.NewFlatNode(newBookConfig(1), 0)
+	snapshot.Path = []int{}
+	restored.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+	restored.Update(nil)
+	expectPageCount(t, restored, []int{1}, 40)
+	expectPageCount(t, restored, []int{2}, 8)
+}
+
+func TestVersionFromV(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.GetVersionFromV = func(b *books.Book) uint64 { return b.PageCount() }
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 0), bookDelta(1, 1, 5, 0)})
+	expectPageCount(t, node, []int{1}, 10)
+
+	node.Set(bookDelta(1, 1, 9, 0))
+	node.Update(nil)
+	expectPageCount(t, node, []int{1}, 10)
+	node.Set(bookDelta(1, 1, 12, 0))
+	node.Update(nil)
+	expectPageCount(t, node, []int{1}, 12)
+	if stale := node.StaleDeltaCount(); stale != 2 {
+		t.Fatalf("StaleDeltaCount() = %d, want 2", stale)
+	}
+}
+
+func TestVersionedDeleteTombstone(t *testing.T) {
+	for _, fromV := range []bool{false, true} {
+		conf := newBookConfig(1)
+		if fromV {
+			conf.GetVersionFromV = func(b *books.Book) uint64 { return b.PageCount() }
+		}
+		node := flatmap.NewFlatNode(conf, 0)
+		node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 5, 5)})
+		node.Set(flatmap.DeltaItem[int]{Keys: []int{1}, Delete: true, Version: 6})
+		node.Update(nil)
+		expectPageCount(t, node, []int{1}, 0)
+
+		node.Set(bookDelta(1, 1, 4, 4))
+		node.Update(nil)
+		expectPageCount(t, node, []int{1}, 0)
+		if stale := node.StaleDeltaCount(); stale != 1 {
+			t.Fatalf("GetVersionFromV %v: StaleDeltaCount() = %d, want 1", fromV, stale)
+		}
+
+		node.Set(bookDelta(1, 1, 7, 7))
+		node.Update(nil)
+		expectPageCount(t, node, []int{1}, 7)
+	}
+}
+
+func TestTombstoneWindow(t *testing.T) {
+	conf := newBookConfig(1)
+	conf.TombstoneWindow = 10
+	node := flatmap.NewFlatNode(conf, 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 5, 5), bookDelta(1, 2, 1, 1)})
+	node.Set(flatmap.DeltaItem[int]{Keys: []int{1}, Delete: true, Version: 6})
+	node.Update(nil)
+
+	node.Set(bookDelta(1, 2, 16, 16)) // the tombstone is now 10 versions behind
+	node.Set(bookDelta(1, 1, 4, 4))
+	node.Update(nil)
+	expectPageCount(t, node, []int{1}, 0)
+
+	node.Set(bookDelta(1, 2, 17, 17)) // and now 11, it is forgotten
+	node.Update(nil)
+	node.Set(bookDelta(1, 1, 4, 4))
+	node.Update(nil)
+	expectPageCount(t, node, []int{1}, 4)
+}
+
+func TestRestoredSnapshotVersionFloor(t *testing.T) {
+	node := flatmap.NewFlatNode(newBookConfig(1), 0)
+	node.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 10, 100), bookDelta(1, 2, 7, 0)})
+	snapshot := node.GetSnapshot([]int{1}, true)
+	if snapshot.Version != 100 {
+		t.Fatalf("snapshot version = %d, want 100", snapshot.Version)
+	}
+
+	restored := flatmap.NewFlatNode(newBookConfig(1), 0)
+	snapshot.Path = []int{}
+	restored.InitializeWithGroupedShardBuffers([]*flatmap.ShardSnapshot[int]{snapshot})
+	restored.Update(nil)
+	// replayed deltas the snapshot already holds, for a restored key, an unversioned one and a missing one
+	restored.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 1, 20, 50), bookDelta(1, 2, 8, 100), bookDelta(1, 3, 9, 50)})
+	expectPageCount(t, restored, []int{1}, 10)
+	expectPageCount(t, restored, []int{2}, 7)
+	expectPageCount(t, restored, []int{3}, 0)
+	if stale := restored.StaleDeltaCount(); stale != 3 {
+		t.Fatalf("StaleDeltaCount() = %d, want 3", stale)
+	}
+
+	restored.FeedDeltaBulk([]flatmap.DeltaItem[int]{bookDelta(1, 2, 8, 101)})
+	expectPageCount(t, restored, []int{2}, 8)
+}